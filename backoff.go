@@ -0,0 +1,154 @@
+package safelock
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrMaxAttemptsExceeded is returned by WaitForLock when the backoff strategy allows no further attempts
+var ErrMaxAttemptsExceeded = errors.New("maximum attempts exceeded")
+
+// Backoff is a strategy for how long to wait between checks of the lock state
+type Backoff interface {
+	// Next returns the delay to wait after the given attempt, which starts at 1.
+	// The previous delay is 0 on the first attempt.
+	// The boolean is false if no further attempts should be made.
+	Next(attempt int, previous time.Duration) (time.Duration, bool)
+}
+
+// ConstantBackoff waits a constant interval plus a random jitter between attempts
+type ConstantBackoff struct {
+	interval time.Duration
+	jitter   time.Duration
+}
+
+// NewConstantBackoff creates a new instance of ConstantBackoff
+func NewConstantBackoff(interval, jitter time.Duration) *ConstantBackoff {
+	return &ConstantBackoff{
+		interval: interval,
+		jitter:   jitter,
+	}
+}
+
+// Next returns the interval plus a random jitter in [0, jitter)
+func (b *ConstantBackoff) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	return b.interval + randomDuration(b.jitter), true
+}
+
+// ExponentialBackoff waits a random duration between zero and an exponentially growing ceiling
+// This is the "full jitter" strategy, which spreads out waiters contending for the same lock.
+type ExponentialBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// NewExponentialBackoff creates a new instance of ExponentialBackoff
+// The ceiling starts at base and doubles every attempt until it reaches max.
+func NewExponentialBackoff(base, max time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		base: base,
+		max:  max,
+	}
+}
+
+// Next returns a random duration in [0, min(max, base * 2^(attempt-1)))
+func (b *ExponentialBackoff) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	ceiling := b.base
+	for i := 1; i < attempt && ceiling < b.max; i++ {
+		// avoid overflowing when doubling large durations
+		if ceiling > b.max/2 {
+			ceiling = b.max
+			break
+		}
+		ceiling *= 2
+	}
+	if ceiling > b.max {
+		ceiling = b.max
+	}
+	return randomDuration(ceiling), true
+}
+
+// DecorrelatedJitterBackoff waits a random duration between base and three times the previous delay
+type DecorrelatedJitterBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// NewDecorrelatedJitterBackoff creates a new instance of DecorrelatedJitterBackoff
+func NewDecorrelatedJitterBackoff(base, max time.Duration) *DecorrelatedJitterBackoff {
+	return &DecorrelatedJitterBackoff{
+		base: base,
+		max:  max,
+	}
+}
+
+// Next returns a random duration in [base, min(max, previous * 3)]
+func (b *DecorrelatedJitterBackoff) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	if previous < b.base {
+		previous = b.base
+	}
+	ceiling := b.max
+	// avoid overflowing when tripling large durations
+	if previous <= b.max/3 {
+		ceiling = previous * 3
+	}
+	if ceiling < b.base {
+		return b.base, true
+	}
+	return b.base + randomDuration(ceiling-b.base+1), true
+}
+
+// MaxIntervalBackoff caps the delay returned by another backoff strategy
+type MaxIntervalBackoff struct {
+	backoff Backoff
+	max     time.Duration
+}
+
+// NewMaxIntervalBackoff creates a new instance of MaxIntervalBackoff
+func NewMaxIntervalBackoff(backoff Backoff, max time.Duration) *MaxIntervalBackoff {
+	return &MaxIntervalBackoff{
+		backoff: backoff,
+		max:     max,
+	}
+}
+
+// Next returns the delay of the wrapped strategy, no longer than max
+func (b *MaxIntervalBackoff) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	delay, ok := b.backoff.Next(attempt, previous)
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay, ok
+}
+
+// MaxAttemptsBackoff limits the number of attempts made with another backoff strategy
+type MaxAttemptsBackoff struct {
+	backoff  Backoff
+	attempts int
+}
+
+// NewMaxAttemptsBackoff creates a new instance of MaxAttemptsBackoff
+func NewMaxAttemptsBackoff(backoff Backoff, attempts int) *MaxAttemptsBackoff {
+	return &MaxAttemptsBackoff{
+		backoff:  backoff,
+		attempts: attempts,
+	}
+}
+
+// Next returns the delay of the wrapped strategy until the attempts have been used up
+func (b *MaxAttemptsBackoff) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	if attempt >= b.attempts {
+		return 0, false
+	}
+	return b.backoff.Next(attempt, previous)
+}
+
+// randomDuration returns a random duration in [0, n) or 0 if n is not positive
+func randomDuration(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	// #nosec G404 jitter does not need a cryptographically secure source
+	return time.Duration(rand.Int63n(int64(n)))
+}
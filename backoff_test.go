@@ -0,0 +1,102 @@
+package safelock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := NewConstantBackoff(10*time.Millisecond, 5*time.Millisecond)
+	for attempt := 1; attempt < 100; attempt++ {
+		delay, ok := b.Next(attempt, 0)
+		assert.True(t, ok)
+		assert.True(t, delay >= 10*time.Millisecond)
+		assert.True(t, delay < 15*time.Millisecond)
+	}
+
+	// No jitter means no randomness
+	b = NewConstantBackoff(10*time.Millisecond, 0)
+	delay, ok := b.Next(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, delay)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := NewExponentialBackoff(10*time.Millisecond, 100*time.Millisecond)
+	for attempt := 1; attempt < 100; attempt++ {
+		delay, ok := b.Next(attempt, 0)
+		assert.True(t, ok)
+		assert.True(t, delay >= 0)
+		assert.True(t, delay < 100*time.Millisecond)
+		if attempt == 1 {
+			assert.True(t, delay < 10*time.Millisecond)
+		}
+	}
+
+	// Large durations must not overflow
+	b = NewExponentialBackoff(time.Hour, time.Duration(1<<62))
+	delay, ok := b.Next(1000, 0)
+	assert.True(t, ok)
+	assert.True(t, delay >= 0)
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	b := NewDecorrelatedJitterBackoff(10*time.Millisecond, 100*time.Millisecond)
+	var delay time.Duration
+	for attempt := 1; attempt < 100; attempt++ {
+		previous := delay
+		next, ok := b.Next(attempt, previous)
+		assert.True(t, ok)
+		assert.True(t, next >= 10*time.Millisecond)
+		assert.True(t, next <= 100*time.Millisecond)
+		if previous > 0 {
+			assert.True(t, next <= previous*3)
+		}
+		delay = next
+	}
+}
+
+func TestMaxIntervalBackoff(t *testing.T) {
+	b := NewMaxIntervalBackoff(NewConstantBackoff(time.Hour, 0), time.Millisecond)
+	delay, ok := b.Next(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, delay)
+}
+
+func TestMaxAttemptsBackoff(t *testing.T) {
+	b := NewMaxAttemptsBackoff(NewConstantBackoff(time.Millisecond, 0), 3)
+	_, ok := b.Next(1, 0)
+	assert.True(t, ok)
+	_, ok = b.Next(2, 0)
+	assert.True(t, ok)
+	_, ok = b.Next(3, 0)
+	assert.False(t, ok)
+}
+
+func TestWaitForLockBackoff(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+	filename := "file.txt"
+
+	l := NewFileLock(0, filename, fs)
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+
+	// Give up after a few fast attempts
+	l.SetBackoff(NewMaxAttemptsBackoff(NewConstantBackoff(time.Millisecond, 0), 3))
+
+	start := time.Now()
+	errWaitForLock := l.WaitForLock(DefaultTimeout)
+	assert.Error(t, errWaitForLock)
+	assert.True(t, errors.Is(errWaitForLock, ErrMaxAttemptsExceeded))
+	assert.True(t, time.Since(start) < DefaultBackoffInterval)
+
+	// Restore the default
+	l.SetBackoff(nil)
+	assert.IsType(t, &ConstantBackoff{}, l.GetBackoff())
+}
@@ -0,0 +1,118 @@
+package safelock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// lockBodySeparator separates the fields of the lock body
+var lockBodySeparator = []byte("__::__")
+
+// errIncompatibleLockBody is returned when a lock body can't be parsed
+var errIncompatibleLockBody = errors.New("incompatible lock file format")
+
+// lockBody is the parsed representation of the lock file
+// The fields are, in order: node, id, timestamp, expires-at and node name.
+// Lock files written by older versions stop after the timestamp or expires-at.
+type lockBody struct {
+	node      []byte
+	id        uuid.UUID
+	timestamp time.Time
+	// expiresAt is zero if the holder did not record an expiration
+	expiresAt time.Time
+	// hasExpiresAt is false for lock files written before expires-at was added
+	hasExpiresAt bool
+	// nodeName is empty if the holder did not have a node name
+	nodeName string
+	// hostname is only known for lock objects that record it outside the lock file
+	hostname string
+	// versionID is only known for lock objects in versioned buckets
+	versionID string
+	// heldVersionID is the version of the locked object held by the holder, it is only known for lock
+	// objects that record it
+	heldVersionID string
+}
+
+// parseLockBody parses the byte slice representation of the lock
+func parseLockBody(body []byte) (*lockBody, error) {
+	parts := bytes.Split(body, lockBodySeparator)
+	if len(parts) < 3 || len(parts) > 5 {
+		return nil, errIncompatibleLockBody
+	}
+	if len(parts[0]) != 2 || len(parts[2]) != 8 {
+		return nil, errIncompatibleLockBody
+	}
+
+	// lock files written before session ids were widened have 8 byte ids
+	id, errParseID := parseSessionID(parts[1])
+	if errParseID != nil {
+		return nil, errIncompatibleLockBody
+	}
+
+	b := &lockBody{
+		node:      parts[0],
+		id:        id,
+		timestamp: decodeTime(parts[2]),
+	}
+
+	if len(parts) >= 4 {
+		if len(parts[3]) != 8 {
+			return nil, errIncompatibleLockBody
+		}
+		b.hasExpiresAt = true
+		b.expiresAt = decodeTime(parts[3])
+	}
+
+	if len(parts) == 5 {
+		b.nodeName = string(parts[4])
+	}
+
+	return b, nil
+}
+
+// ttl returns the time to live intended by the holder and false if the holder did not record one
+// A time to live of zero means the holder intended the lock to never expire.
+func (b *lockBody) ttl() (time.Duration, bool) {
+	if !b.hasExpiresAt {
+		return 0, false
+	}
+	if b.expiresAt.IsZero() {
+		return 0, true
+	}
+	// Both timestamps come from the holder's clock so the difference is not affected by skew
+	return b.expiresAt.Sub(b.timestamp), true
+}
+
+// sameLockBody returns true if both bodies were written by the same session at the same time
+// On versioned buckets the bodies must also be the same version of the lock object.
+func sameLockBody(a, b *lockBody) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if len(a.versionID) > 0 && len(b.versionID) > 0 && a.versionID != b.versionID {
+		return false
+	}
+	return bytes.Equal(a.node, b.node) && a.id == b.id && a.timestamp.Equal(b.timestamp)
+}
+
+// encodeTime encodes a time as little endian nanoseconds since the epoch, the zero time is encoded as 0
+func encodeTime(t time.Time) []byte {
+	buf := make([]byte, 8)
+	if !t.IsZero() {
+		binary.LittleEndian.PutUint64(buf, uint64(t.UnixNano()))
+	}
+	return buf
+}
+
+// decodeTime decodes a time encoded by encodeTime
+func decodeTime(buf []byte) time.Time {
+	ns := binary.LittleEndian.Uint64(buf)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ns))
+}
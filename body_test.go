@@ -0,0 +1,114 @@
+package safelock
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLockBody(t *testing.T) {
+
+	now := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	l := NewSafeLockWithClock(1, NewFakeClock(now))
+	l.SetTimeout(time.Minute)
+
+	b, errParse := parseLockBody(l.GetLockBody())
+	assert.NoError(t, errParse)
+	assert.Equal(t, l.GetNodeBytes(), b.node)
+	assert.Equal(t, l.GetSessionID(), b.id)
+	assert.True(t, now.Equal(b.timestamp))
+	assert.True(t, now.Add(time.Minute).Equal(b.expiresAt))
+	ttl, ok := b.ttl()
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+
+	// A lock without a timeout never expires
+	l.SetTimeout(0)
+	b, errParse = parseLockBody(l.GetLockBody())
+	assert.NoError(t, errParse)
+	assert.True(t, b.expiresAt.IsZero())
+	ttl, ok = b.ttl()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	// Lock files written before expires-at was added don't record a time to live
+	legacy := bytes.Join(bytes.Split(l.GetLockBody(), lockBodySeparator)[:3], lockBodySeparator)
+	b, errParse = parseLockBody(legacy)
+	assert.NoError(t, errParse)
+	assert.True(t, now.Equal(b.timestamp))
+	_, ok = b.ttl()
+	assert.False(t, ok)
+
+	// Garbage can't be parsed
+	_, errParse = parseLockBody([]byte("not a lock"))
+	assert.Error(t, errParse)
+	_, errParse = parseLockBody([]byte("a__::__b__::__c"))
+	assert.Error(t, errParse)
+}
+
+func TestSafeLockIsExpired(t *testing.T) {
+
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+	written := c.Now()
+
+	holder := NewSafeLockWithClock(1, c)
+	holder.SetTimeout(time.Minute)
+	b, errParse := parseLockBody(holder.GetLockBody())
+	assert.NoError(t, errParse)
+
+	// The holder's time to live is honored rather than the observer's timeout
+	observer := NewSafeLockWithClock(0, c)
+	observer.SetTimeout(time.Second)
+	observer.SetClockSkew(0)
+	c.Advance(30 * time.Second)
+	assert.False(t, observer.isExpired(b, written))
+	c.Advance(31 * time.Second)
+	assert.True(t, observer.isExpired(b, written))
+
+	// The observer may limit the time to live it accepts
+	c.Set(written)
+	observer.SetMaxTTL(10 * time.Second)
+	assert.Equal(t, 10*time.Second, observer.GetMaxTTL())
+	c.Advance(11 * time.Second)
+	assert.True(t, observer.isExpired(b, written))
+
+	// Locks that never expire are limited by the maximum as well
+	holder.SetTimeout(0)
+	b, errParse = parseLockBody(holder.GetLockBody())
+	assert.NoError(t, errParse)
+	assert.True(t, observer.isExpired(b, written))
+	observer.SetMaxTTL(0)
+	assert.False(t, observer.isExpired(b, written))
+
+	// Lock files that don't record a time to live use the observer's timeout
+	legacy := bytes.Join(bytes.Split(holder.GetLockBody(), lockBodySeparator)[:3], lockBodySeparator)
+	b, errParse = parseLockBody(legacy)
+	assert.NoError(t, errParse)
+	assert.True(t, observer.isExpired(b, written))
+	observer.SetTimeout(time.Hour)
+	assert.False(t, observer.isExpired(b, written))
+}
+
+func TestParseLockBodyLegacyID(t *testing.T) {
+
+	// Lock files written before session ids were widened have 8 byte ids
+	l := NewSafeLock(3)
+	l.SetID(37583)
+
+	legacy := l.GetNodeBytes()
+	legacy = append(legacy, lockBodySeparator...)
+	legacy = append(legacy, 0xcf, 0x92, 0, 0, 0, 0, 0, 0)
+	legacy = append(legacy, lockBodySeparator...)
+	legacy = append(legacy, encodeTime(time.Now())...)
+
+	b, errParse := parseLockBody(legacy)
+	assert.NoError(t, errParse)
+	assert.Equal(t, l.GetSessionID(), b.id)
+
+	ownedNode, ownedSession := l.lockOwnership(b)
+	assert.True(t, ownedNode)
+	assert.True(t, ownedSession)
+}
@@ -0,0 +1,117 @@
+package safelock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers used for lock timestamps, expiry and waiting
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is a Clock backed by the operating system clock
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse and then sends the current time on the returned channel
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// fakeTimer is a pending call to FakeClock.After
+type fakeTimer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// FakeClock is a Clock whose time only changes when told to, for use in tests
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []fakeTimer
+}
+
+// NewFakeClock creates a new instance of FakeClock set to the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{
+		now: now,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once the clock has been advanced by the duration
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{deadline: c.now.Add(d), c: ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the fake time forward and fires any timers that have expired
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set changes the fake time and fires any timers that have expired
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(now)
+}
+
+// set changes the fake time and fires any timers that have expired, the mutex must be held
+func (c *FakeClock) set(now time.Time) {
+	c.now = now
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if now.Before(t.deadline) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- now
+	}
+	c.timers = pending
+	c.cond.Broadcast()
+}
+
+// Timers returns the number of timers waiting for the fake time to advance
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers are waiting for the fake time to advance
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
@@ -0,0 +1,73 @@
+package safelock
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+
+	start := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	assert.Equal(t, start, c.Now())
+
+	// Non-positive durations fire immediately
+	select {
+	case now := <-c.After(0):
+		assert.Equal(t, start, now)
+	default:
+		t.Fatal("After(0) did not fire immediately")
+	}
+
+	after := c.After(time.Second)
+	assert.Equal(t, 1, c.Timers())
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatal("After fired before its deadline")
+	default:
+	}
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case now := <-after:
+		assert.Equal(t, start.Add(time.Second), now)
+	default:
+		t.Fatal("After did not fire at its deadline")
+	}
+	assert.Equal(t, 0, c.Timers())
+
+	// BlockUntil waits for timers to be created
+	done := make(chan struct{})
+	go func() {
+		c.BlockUntil(1)
+		close(done)
+	}()
+	after = c.After(time.Minute)
+	<-done
+
+	c.Set(start.Add(time.Hour))
+	<-after
+	assert.Equal(t, start.Add(time.Hour), c.Now())
+}
+
+func TestSafeLockClock(t *testing.T) {
+
+	start := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	l := NewSafeLockWithClock(0, c)
+	assert.Equal(t, c, l.GetClock())
+	// The session id records the creation time
+	expected, _ := newSessionID(c, rand.Reader)
+	actual := l.GetSessionID()
+	assert.Equal(t, expected[:6], actual[:6])
+
+	// Restore the system clock
+	l.SetClock(nil)
+	assert.Equal(t, SystemClock{}, l.GetClock())
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"time"
 
 	"github.com/deptofdefense/safelock"
 
@@ -21,13 +20,17 @@ const (
 	flagFileLockAction   = "action"
 	flagFileLockID       = "lock-id"
 	flagFileLockNode     = "node"
+	flagFileLockNodeName = "node-name"
+	flagFileLockDir      = "lock-dir"
 )
 
 func initFileLockFlags(flag *pflag.FlagSet) {
 	flag.String(flagFileLockFilename, "", "The filename")
 	flag.String(flagFileLockAction, actionLock, "The action to use")
-	flag.Uint64(flagFileLockID, uint64(time.Now().UnixNano()), "The id of the lock to act upon")
-	flag.Uint(flagFileLockNode, math.MaxInt, "The node of the lock to act upon")
+	flag.String(flagFileLockID, "", "The id of the lock to act upon, a UUID that is generated when locking if not set")
+	flag.Uint(flagFileLockNode, math.MaxUint16, "The node of the lock to act upon")
+	flag.String(flagFileLockDir, "", "The directory of the lock file, the lock file is next to the file if not set")
+	flag.String(flagFileLockNodeName, "", "The node name of the lock to act upon, compared instead of the node when both locks have one")
 }
 
 func checkFileLockConfig(v *viper.Viper) error {
@@ -37,15 +40,20 @@ func checkFileLockConfig(v *viper.Viper) error {
 		return errors.New("A filename is required")
 	}
 
+	node := v.GetUint(flagFileLockNode)
+	if node > math.MaxUint16 {
+		return fmt.Errorf("Node %d is not valid, must be at most %d", node, math.MaxUint16)
+	}
+
 	action := v.GetString(flagFileLockAction)
 	if !stringSliceContains(validActions, action) {
 		return fmt.Errorf("Action %q is not valid, must be one of %v", action, validActions)
 	}
-	if action == actionUnlock {
-		lockID := v.GetString(flagFileLockID)
-		if len(lockID) == 0 {
-			return errors.New("A lock ID is required when unlocking")
-		}
+	lockID := v.GetString(flagFileLockID)
+	if action == actionUnlock && len(lockID) == 0 {
+		return errors.New("A lock ID is required when unlocking")
+	}
+	if len(lockID) > 0 {
 		_, errParse := uuid.Parse(lockID)
 		if errParse != nil {
 			return fmt.Errorf("Lock ID %q is not a valid UUID", lockID)
@@ -74,11 +82,30 @@ func fileLockCmd(cmd *cobra.Command, args []string) error {
 
 	node := v.GetUint(flagFileLockNode)
 
-	fs := afero.NewOsFs()
-	l := safelock.NewFileLock(uint16(node), filename, fs)
+	opts := []safelock.Option{
+		safelock.WithNodeName(v.GetString(flagFileLockNodeName)),
+	}
+	if lockID := v.GetString(flagFileLockID); len(lockID) > 0 {
+		// The lock ID has already been validated
+		opts = append(opts, safelock.WithID(uuid.MustParse(lockID)))
+	}
+	if lockDir := v.GetString(flagFileLockDir); len(lockDir) > 0 {
+		opts = append(opts, safelock.WithLockDir(lockDir))
+	}
 
-	lockID := v.GetUint64(flagFileLockID)
-	l.SetID(lockID)
+	logger, errLogger := loggerFromConfig(v)
+	if errLogger != nil {
+		return errLogger
+	}
+	if logger != nil {
+		opts = append(opts, safelock.WithLogger(logger))
+	}
+
+	fs := afero.NewOsFs()
+	l, errNew := safelock.NewFileLockWithOptions(uint16(node), filename, fs, opts...)
+	if errNew != nil {
+		return errNew
+	}
 
 	switch action {
 	case actionLock:
@@ -90,6 +117,7 @@ func fileLockCmd(cmd *cobra.Command, args []string) error {
 		if errLock != nil {
 			return errLock
 		}
+		fmt.Println(l.GetSessionID())
 	case actionUnlock:
 		errUnlock := l.Unlock()
 		if errUnlock != nil {
@@ -2,18 +2,43 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 const (
 	// Generic Flags for most commands
 	flagAWSRegion = "aws-region"
+	flagLogLevel  = "log-level"
 )
 
+var validLogLevels = []string{"debug", "info", "warn", "error"}
+
+func initLogFlags(flag *pflag.FlagSet) {
+	flag.String(flagLogLevel, "", fmt.Sprintf("Log lock operations to stderr at this level, one of %v, nothing is logged if not set", validLogLevels))
+}
+
+// loggerFromConfig returns the logger for lock operations or nil if logging is disabled
+func loggerFromConfig(v *viper.Viper) (*slog.Logger, error) {
+	logLevel := v.GetString(flagLogLevel)
+	if len(logLevel) == 0 {
+		return nil, nil
+	}
+	if !stringSliceContains(validLogLevels, logLevel) {
+		return nil, fmt.Errorf("Log level %q is not valid, must be one of %v", logLevel, validLogLevels)
+	}
+	var level slog.Level
+	if errLevel := level.UnmarshalText([]byte(logLevel)); errLevel != nil {
+		return nil, errLevel
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})), nil
+}
+
 func initViper(cmd *cobra.Command) (*viper.Viper, error) {
 	v := viper.New()
 	errBind := v.BindPFlags(cmd.Flags())
@@ -51,6 +76,7 @@ func main() {
 		RunE:                  fileLockCmd,
 	}
 	initFileLockFlags(fileLockCommand.Flags())
+	initLogFlags(fileLockCommand.Flags())
 
 	s3ObjectLockCommand := &cobra.Command{
 		Use:                   `s3object [flags]`,
@@ -61,6 +87,7 @@ func main() {
 		RunE:                  s3ObjectLockCmd,
 	}
 	initS3ObjectLockFlags(s3ObjectLockCommand.Flags())
+	initLogFlags(s3ObjectLockCommand.Flags())
 
 	rootCommand.AddCommand(
 		fileLockCommand,
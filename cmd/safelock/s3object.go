@@ -2,15 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math"
-	"time"
 
 	"github.com/deptofdefense/safelock"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -19,29 +20,83 @@ import (
 
 const (
 	// S3 Object Lock flags
-	flagS3ObjectLockBucket    = "s3-bucket"
-	flagS3ObjectLockKey       = "s3-key"
-	flagS3ObjectLockKMSKeyArn = "s3-kms-key-arn"
-	flagS3ObjectLockAction    = "action"
-	flagS3ObjectLockID        = "lock-id"
-	flagS3ObjectLockNode      = "node"
+	flagS3ObjectLockBucket     = "s3-bucket"
+	flagS3ObjectLockKey        = "s3-key"
+	flagS3ObjectLockKMSKeyArn  = "s3-kms-key-arn"
+	flagS3ObjectLockSSE        = "s3-sse"
+	flagS3ObjectLockKMSContext = "s3-kms-encryption-context"
+	flagS3ObjectLockBucketKey  = "s3-bucket-key"
+	flagS3ObjectLockSSECKey    = "s3-sse-c-key"
+	flagS3ObjectLockTags       = "s3-tags"
+	flagS3ObjectLockHolderTags = "s3-holder-tags"
+	flagS3ObjectLockLockBucket = "s3-lock-bucket"
+	flagS3ObjectLockLockPrefix = "s3-lock-prefix"
+	flagS3ObjectLockEndpoint   = "s3-endpoint"
+	flagS3ObjectLockPathStyle  = "s3-path-style"
+	flagS3ObjectLockACL        = "s3-acl"
+	flagS3ObjectLockAcquire    = "s3-acquire-mode"
+	flagS3ObjectLockHold       = "s3-hold-mode"
+	flagS3ObjectLockVersioning = "s3-versioning"
+	flagS3ObjectLockAction     = "action"
+	flagS3ObjectLockID         = "lock-id"
+	flagS3ObjectLockNode       = "node"
+	flagS3ObjectLockNodeName   = "node-name"
 
 	actionLock   = "lock"
 	actionUnlock = "unlock"
+
+	s3ACLNone      = "none"
+	s3AcquireProbe = "probe"
+
+	s3VersioningAuto     = "auto"
+	s3VersioningEnabled  = "enabled"
+	s3VersioningDisabled = "disabled"
 )
 
 var (
-	validActions = []string{actionLock, actionUnlock}
+	validActions           = []string{actionLock, actionUnlock}
+	validS3EncryptionModes = []string{
+		string(safelock.S3EncryptionNone),
+		string(safelock.S3EncryptionSSES3),
+		string(safelock.S3EncryptionSSEKMS),
+		string(safelock.S3EncryptionSSEC),
+	}
+	validS3HoldModes = []string{
+		string(safelock.S3HoldNone),
+		string(safelock.S3HoldLegalHold),
+		string(safelock.S3HoldGovernanceRetention),
+	}
+	validS3Versionings  = []string{s3VersioningAuto, s3VersioningEnabled, s3VersioningDisabled}
+	validS3AcquireModes = []string{
+		string(safelock.S3AcquireCheckThenPut),
+		string(safelock.S3AcquireConditionalPut),
+		s3AcquireProbe,
+	}
 )
 
 func initS3ObjectLockFlags(flag *pflag.FlagSet) {
 	flag.String(flagAWSRegion, "us-west-2", "The AWS region")
 	flag.String(flagS3ObjectLockBucket, "", "The s3 bucket")
 	flag.String(flagS3ObjectLockKey, "", "The s3 key")
-	flag.String(flagS3ObjectLockKMSKeyArn, "", "The s3 kms key ARN")
+	flag.String(flagS3ObjectLockKMSKeyArn, "", "The s3 kms key ARN, implies sse-kms encryption if --s3-sse is not set")
+	flag.String(flagS3ObjectLockSSE, "", fmt.Sprintf("The s3 server-side encryption, one of %v, the bucket's default encryption is used if not set", validS3EncryptionModes))
+	flag.StringToString(flagS3ObjectLockKMSContext, map[string]string{}, "The s3 kms encryption context for sse-kms")
+	flag.Bool(flagS3ObjectLockBucketKey, false, "Use an s3 bucket key for sse-kms")
+	flag.String(flagS3ObjectLockSSECKey, "", "The base64 encoded 256-bit key for sse-c")
+	flag.String(flagS3ObjectLockLockBucket, "", "The s3 bucket of the lock object, which is keyed by the object's bucket and key, the bucket of the object is used if not set")
+	flag.String(flagS3ObjectLockLockPrefix, "", "The s3 prefix of the lock object, which is named by a hash of the object's bucket and key, the lock object is next to the object if not set")
+	flag.String(flagS3ObjectLockEndpoint, "", "The URL of an s3 compatible endpoint, such as MinIO or Ceph RGW")
+	flag.Bool(flagS3ObjectLockPathStyle, false, "Use path-style addressing of s3 buckets")
+	flag.String(flagS3ObjectLockACL, string(safelock.DefaultS3ACL), fmt.Sprintf("The canned ACL of the s3 lock object, or %q for buckets with ACLs disabled", s3ACLNone))
+	flag.String(flagS3ObjectLockAcquire, string(safelock.S3AcquireCheckThenPut), fmt.Sprintf("How the s3 lock object is written, one of %v, %q selects the strongest mode supported by the store", validS3AcquireModes, s3AcquireProbe))
+	flag.String(flagS3ObjectLockHold, string(safelock.S3HoldNone), fmt.Sprintf("How S3 Object Lock holds the s3 object while it is locked, one of %v", validS3HoldModes))
+	flag.String(flagS3ObjectLockVersioning, s3VersioningDisabled, fmt.Sprintf("Whether the bucket of the s3 lock object is versioned, one of %v, %q detects the bucket's versioning", validS3Versionings, s3VersioningAuto))
+	flag.StringToString(flagS3ObjectLockTags, map[string]string{}, "The tags added to the s3 lock object, in addition to the holder's tags if enabled")
+	flag.Bool(flagS3ObjectLockHolderTags, false, "Record the holder in the tags of the s3 lock object, which requires the s3:PutObjectTagging permission")
 	flag.String(flagS3ObjectLockAction, actionLock, "The action to use")
-	flag.Uint64(flagS3ObjectLockID, uint64(time.Now().UnixNano()), "The id of the lock to act upon")
-	flag.Uint(flagS3ObjectLockNode, math.MaxInt, "The node of the lock to act upon")
+	flag.String(flagS3ObjectLockID, "", "The id of the lock to act upon, a UUID that is generated when locking if not set")
+	flag.Uint(flagS3ObjectLockNode, math.MaxUint16, "The node of the lock to act upon")
+	flag.String(flagS3ObjectLockNodeName, "", "The node name of the lock to act upon, compared instead of the node when both locks have one")
 }
 
 func checkS3ObjectLockConfig(v *viper.Viper) error {
@@ -61,20 +116,39 @@ func checkS3ObjectLockConfig(v *viper.Viper) error {
 		return errors.New("An s3 key is required")
 	}
 
-	kmsKeyArn := v.GetString(flagS3ObjectLockKMSKeyArn)
-	if len(kmsKeyArn) == 0 {
-		return errors.New("An s3 kms key ARN is required")
+	if _, errEncryption := s3EncryptionFromConfig(v); errEncryption != nil {
+		return errEncryption
+	}
+
+	acquire := v.GetString(flagS3ObjectLockAcquire)
+	if !stringSliceContains(validS3AcquireModes, acquire) {
+		return fmt.Errorf("S3 acquire mode %q is not valid, must be one of %v", acquire, validS3AcquireModes)
+	}
+
+	hold := v.GetString(flagS3ObjectLockHold)
+	if !stringSliceContains(validS3HoldModes, hold) {
+		return fmt.Errorf("S3 hold mode %q is not valid, must be one of %v", hold, validS3HoldModes)
+	}
+
+	versioning := v.GetString(flagS3ObjectLockVersioning)
+	if !stringSliceContains(validS3Versionings, versioning) {
+		return fmt.Errorf("S3 versioning %q is not valid, must be one of %v", versioning, validS3Versionings)
+	}
+
+	node := v.GetUint(flagS3ObjectLockNode)
+	if node > math.MaxUint16 {
+		return fmt.Errorf("Node %d is not valid, must be at most %d", node, math.MaxUint16)
 	}
 
 	action := v.GetString(flagS3ObjectLockAction)
 	if !stringSliceContains(validActions, action) {
 		return fmt.Errorf("Action %q is not valid, must be one of %v", action, validActions)
 	}
-	if action == actionUnlock {
-		lockID := v.GetString(flagS3ObjectLockID)
-		if len(lockID) == 0 {
-			return errors.New("A lock ID is required when unlocking")
-		}
+	lockID := v.GetString(flagS3ObjectLockID)
+	if action == actionUnlock && len(lockID) == 0 {
+		return errors.New("A lock ID is required when unlocking")
+	}
+	if len(lockID) > 0 {
 		_, errParse := uuid.Parse(lockID)
 		if errParse != nil {
 			return fmt.Errorf("Lock ID %q is not a valid UUID", lockID)
@@ -84,6 +158,30 @@ func checkS3ObjectLockConfig(v *viper.Viper) error {
 	return nil
 }
 
+// s3EncryptionFromConfig returns the encryption for the lock object configured by the flags
+func s3EncryptionFromConfig(v *viper.Viper) (safelock.S3Encryption, error) {
+	encryption := safelock.S3Encryption{
+		Mode:                 safelock.S3EncryptionMode(v.GetString(flagS3ObjectLockSSE)),
+		KMSKeyID:             v.GetString(flagS3ObjectLockKMSKeyArn),
+		KMSEncryptionContext: v.GetStringMapString(flagS3ObjectLockKMSContext),
+		BucketKeyEnabled:     v.GetBool(flagS3ObjectLockBucketKey),
+	}
+	if len(encryption.Mode) == 0 && len(encryption.KMSKeyID) > 0 {
+		encryption.Mode = safelock.S3EncryptionSSEKMS
+	}
+	if customerKey := v.GetString(flagS3ObjectLockSSECKey); len(customerKey) > 0 {
+		key, errDecode := base64.StdEncoding.DecodeString(customerKey)
+		if errDecode != nil {
+			return safelock.S3Encryption{}, fmt.Errorf("SSE-C key is not valid base64: %w", errDecode)
+		}
+		encryption.CustomerKey = key
+	}
+	if errValidate := encryption.Validate(); errValidate != nil {
+		return safelock.S3Encryption{}, fmt.Errorf("S3 encryption is not valid: %w", errValidate)
+	}
+	return encryption, nil
+}
+
 func s3ObjectLockCmd(cmd *cobra.Command, args []string) error {
 	v, errViper := initViper(cmd)
 	if errViper != nil {
@@ -101,21 +199,76 @@ func s3ObjectLockCmd(cmd *cobra.Command, args []string) error {
 	awsRegion := v.GetString(flagAWSRegion)
 	bucket := v.GetString(flagS3ObjectLockBucket)
 	key := v.GetString(flagS3ObjectLockKey)
-	kmsKeyArn := v.GetString(flagS3ObjectLockKMSKeyArn)
 	action := v.GetString(flagS3ObjectLockAction)
 
 	awsCfg, errCfg := config.LoadDefaultConfig(context.TODO(), config.WithRegion(awsRegion))
 	if errCfg != nil {
 		return errCfg
 	}
-	svcS3 := s3.NewFromConfig(awsCfg)
+	svcS3 := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint := v.GetString(flagS3ObjectLockEndpoint); len(endpoint) > 0 {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+		}
+		o.UsePathStyle = v.GetBool(flagS3ObjectLockPathStyle)
+	})
 
 	node := v.GetUint(flagS3ObjectLockNode)
 
-	l := safelock.NewS3ObjectLock(uint16(node), bucket, key, kmsKeyArn, svcS3)
+	// The encryption has already been validated
+	encryption, _ := s3EncryptionFromConfig(v)
+
+	opts := []safelock.Option{
+		safelock.WithNodeName(v.GetString(flagS3ObjectLockNodeName)),
+		safelock.WithS3Encryption(encryption),
+		safelock.WithS3HolderTags(v.GetBool(flagS3ObjectLockHolderTags)),
+		safelock.WithS3Tags(v.GetStringMapString(flagS3ObjectLockTags)),
+		safelock.WithS3HoldMode(safelock.S3HoldMode(v.GetString(flagS3ObjectLockHold))),
+		safelock.WithS3Versioned(v.GetString(flagS3ObjectLockVersioning) == s3VersioningEnabled),
+	}
+	if acl := v.GetString(flagS3ObjectLockACL); acl == s3ACLNone {
+		opts = append(opts, safelock.WithS3ACL(""))
+	} else {
+		opts = append(opts, safelock.WithS3ACL(types.ObjectCannedACL(acl)))
+	}
+	if acquire := v.GetString(flagS3ObjectLockAcquire); acquire != s3AcquireProbe {
+		opts = append(opts, safelock.WithS3AcquireMode(safelock.S3AcquireMode(acquire)))
+	}
+	if lockID := v.GetString(flagS3ObjectLockID); len(lockID) > 0 {
+		// The lock ID has already been validated
+		opts = append(opts, safelock.WithID(uuid.MustParse(lockID)))
+	}
+	if lockBucket, lockPrefix := v.GetString(flagS3ObjectLockLockBucket), v.GetString(flagS3ObjectLockLockPrefix); len(lockPrefix) > 0 {
+		opts = append(opts, safelock.WithS3LockLocator(safelock.S3LockInPrefix(lockBucket, lockPrefix)))
+	} else if len(lockBucket) > 0 {
+		opts = append(opts, safelock.WithS3LockLocator(safelock.S3LockInBucket(lockBucket)))
+	}
+
+	logger, errLogger := loggerFromConfig(v)
+	if errLogger != nil {
+		return errLogger
+	}
+	if logger != nil {
+		opts = append(opts, safelock.WithLogger(logger))
+	}
+
+	l, errNew := safelock.NewS3ObjectLockWithOptions(uint16(node), bucket, key, svcS3, opts...)
+	if errNew != nil {
+		return errNew
+	}
+
+	if v.GetString(flagS3ObjectLockVersioning) == s3VersioningAuto {
+		if errDetect := l.DetectS3Versioning(context.TODO()); errDetect != nil {
+			return errDetect
+		}
+	}
 
-	lockID := v.GetUint64(flagS3ObjectLockID)
-	l.SetID(lockID)
+	if v.GetString(flagS3ObjectLockAcquire) == s3AcquireProbe && action == actionLock {
+		capabilities, errProbe := l.ProbeS3Capabilities(context.TODO())
+		if errProbe != nil {
+			return fmt.Errorf("unable to probe s3 capabilities: %w", errProbe)
+		}
+		l.ApplyS3Capabilities(capabilities)
+	}
 
 	switch action {
 	case actionLock:
@@ -127,7 +280,7 @@ func s3ObjectLockCmd(cmd *cobra.Command, args []string) error {
 		if errLock != nil {
 			return errLock
 		}
-		fmt.Println(l.GetID())
+		fmt.Println(l.GetSessionID())
 	case actionUnlock:
 		errUnlock := l.Unlock()
 		if errUnlock != nil {
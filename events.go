@@ -0,0 +1,191 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lockEvents reports the events of an operation on a lock to the lock's logger, metrics, tracer and observers
+// The operation's span is ended when its outcome is reported.
+type lockEvents struct {
+	l       *SafeLock
+	uri     string
+	log     *slog.Logger
+	metrics *Metrics
+	// ctx is the context of the operation, carrying its span
+	ctx  context.Context
+	span trace.Span
+	// holder is the holder of the lock when Lock finds it held
+	holder *lockBody
+	// takeover is the lock removed by Lock to take it over, which is reported once the lock is acquired
+	takeover        *lockBody
+	takeoverExpired bool
+}
+
+// newLockEvents starts the span of an operation on the lock at the URI and returns the reporter of its events
+func (l *SafeLock) newLockEvents(ctx context.Context, operation, uri string) *lockEvents {
+	ctx, span := l.startSpan(ctx, operation, uri)
+	return &lockEvents{
+		l:       l,
+		uri:     uri,
+		log:     l.lockLogger(uri),
+		metrics: l.GetMetrics(),
+		ctx:     ctx,
+		span:    span,
+	}
+}
+
+// acquired reports the outcome of Lock
+func (e *lockEvents) acquired(start time.Time, errLock error) {
+	duration := slog.Duration("duration", e.l.GetClock().Now().Sub(start))
+	switch {
+	case errLock == nil:
+		e.log.InfoContext(e.ctx, "lock acquired", duration)
+	case errors.Is(errLock, ErrLocked):
+		e.log.InfoContext(e.ctx, "lock is held by another session", duration, slog.Any("error", errLock))
+	default:
+		e.log.ErrorContext(e.ctx, "unable to acquire lock", duration, slog.Any("error", errLock))
+	}
+	e.metrics.observeAcquire(e.uri, errLock)
+	endSpan(e.span, "acquired", errLock)
+
+	switch {
+	case errLock == nil:
+		if e.takeover != nil {
+			e.tookOver()
+		}
+		if !e.l.holding.Swap(true) {
+			e.metrics.observeHolding(e.uri, true)
+		}
+		e.l.notify(e.ctx, e.uri, nil, nil, LockObserver.OnAcquired)
+	case errors.Is(errLock, ErrLocked):
+		holder := e.l.bodyOwner(e.holder)
+		// a lock held by this session is not lost because Lock was called again
+		if e.holder == nil || !e.l.isOwnerSession(e.holder) {
+			e.l.lost(e.ctx, e.uri, holder, errLock)
+		}
+		e.l.notify(e.ctx, e.uri, holder, nil, LockObserver.OnContended)
+	}
+}
+
+// takingOver logs that Lock is removing a lock that has expired or was held by a prior session of this
+// node, the takeover is reported if the lock is then acquired
+func (e *lockEvents) takingOver(held *lockBody, expired bool) {
+	e.takeover = held
+	e.takeoverExpired = expired
+	e.log.InfoContext(e.ctx, "taking over lock", slog.String("reason", e.takeoverReason()), holderAttr(held))
+}
+
+// tookOver reports the takeover of a lock once the lock that replaced it was written
+func (e *lockEvents) tookOver() {
+	reason := e.takeoverReason()
+	e.metrics.observeTakeover(e.uri, reason)
+	e.span.AddEvent("takeover", trace.WithAttributes(append(holderAttributes(e.takeover), attribute.String("safelock.reason", reason))...))
+	if e.takeoverExpired {
+		e.l.notify(e.ctx, e.uri, e.l.bodyOwner(e.takeover), nil, LockObserver.OnExpiredTakeover)
+	}
+}
+
+// takeoverReason returns the reason of the takeover by Lock
+func (e *lockEvents) takeoverReason() string {
+	if e.takeoverExpired {
+		return takeoverExpired
+	}
+	return takeoverPriorSession
+}
+
+// released reports the outcome of Unlock or ForceUnlock for the lock that was held
+// The duration is how long the lock was held, according to the holder's clock.
+func (e *lockEvents) released(held *lockBody, force bool, errUnlock error) {
+	outcome := "released"
+	if force {
+		outcome = "force_unlocked"
+	}
+	if errUnlock != nil {
+		e.log.ErrorContext(e.ctx, "unable to release lock", slog.Bool("force", force), holderAttr(held), slog.Any("error", errUnlock))
+		e.metrics.observeReleaseFailure(e.uri, force, errUnlock)
+		endSpan(e.span, outcome, errUnlock, holderAttributes(held)...)
+		// the lock of this session was removed or replaced
+		if !force && (errors.Is(errUnlock, ErrNotLocked) || errors.Is(errUnlock, ErrWrongNode) || errors.Is(errUnlock, ErrWrongSession)) {
+			e.l.lost(e.ctx, e.uri, e.l.bodyOwner(held), errUnlock)
+		}
+		return
+	}
+
+	var heldFor time.Duration
+	attrs := []any{holderAttr(held)}
+	if held != nil && !held.timestamp.IsZero() {
+		heldFor = e.l.GetClock().Now().Sub(held.timestamp)
+		attrs = append(attrs, slog.Duration("duration", heldFor))
+	}
+	if force {
+		e.log.WarnContext(e.ctx, "lock force unlocked", attrs...)
+	} else {
+		e.log.InfoContext(e.ctx, "lock released", attrs...)
+	}
+	ownSession := held != nil && held.id == e.l.id
+	e.metrics.observeRelease(e.uri, force, heldFor)
+	endSpan(e.span, outcome, nil, append(holderAttributes(held), attribute.Float64("safelock.hold_duration", heldFor.Seconds()))...)
+
+	holder := e.l.bodyOwner(held)
+	switch {
+	case ownSession:
+		if e.l.holding.Swap(false) {
+			e.metrics.observeHolding(e.uri, false)
+		}
+	case !force:
+		// an expired lock of another session was released, so the lock of this session had been lost
+		e.l.lost(e.ctx, e.uri, holder, nil)
+	}
+	if force {
+		e.l.notify(e.ctx, e.uri, holder, nil, LockObserver.OnForceUnlocked)
+	} else {
+		e.l.notify(e.ctx, e.uri, holder, nil, LockObserver.OnReleased)
+	}
+}
+
+// waiting reports an attempt of WaitForLock that found the lock unavailable
+func (e *lockEvents) waiting(attempt int, delay time.Duration) {
+	e.log.DebugContext(e.ctx, "waiting for lock", slog.Int("attempt", attempt), slog.Duration("delay", delay))
+}
+
+// waited reports the outcome of WaitForLock and returns its error
+func (e *lockEvents) waited(start time.Time, attempts int, errWait error) error {
+	duration := e.l.GetClock().Now().Sub(start)
+	attrs := []any{slog.Int("attempts", attempts), slog.Duration("duration", duration)}
+	switch {
+	case errWait == nil:
+		e.log.DebugContext(e.ctx, "lock is available", attrs...)
+	case errors.Is(errWait, context.DeadlineExceeded):
+		e.log.WarnContext(e.ctx, "timed out waiting for lock", attrs...)
+	case errors.Is(errWait, ErrMaxAttemptsExceeded):
+		e.log.WarnContext(e.ctx, "gave up waiting for lock", attrs...)
+	default:
+		e.log.ErrorContext(e.ctx, "unable to check lock while waiting", append(attrs, slog.Any("error", errWait))...)
+	}
+	e.metrics.observeWait(e.uri, duration, errWait)
+	endSpan(e.span, "available", errWait,
+		attribute.Int("safelock.attempts", attempts),
+		attribute.Float64("safelock.wait_duration", duration.Seconds()))
+	return errWait
+}
+
+// retrying reports a backend operation that failed with a transient error and will be retried
+func (e *lockEvents) retrying(attempt int, delay time.Duration, errOp error) {
+	e.log.WarnContext(e.ctx, "retrying backend operation", slog.Int("attempt", attempt), slog.Duration("delay", delay), slog.Any("error", errOp))
+	e.span.AddEvent("retry", trace.WithAttributes(
+		attribute.Int("safelock.attempt", attempt),
+		attribute.Float64("safelock.delay", delay.Seconds()),
+		attribute.String("safelock.error", errOp.Error())))
+}
+
+// checked reports the outcome of GetLockState and returns it
+func (e *lockEvents) checked(lockState LockState, errGetLockState error) (LockState, error) {
+	endSpan(e.span, string(lockState), errGetLockState)
+	return lockState, errGetLockState
+}
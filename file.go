@@ -1,13 +1,13 @@
 package safelock
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"net/url"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spf13/afero"
@@ -21,9 +21,12 @@ type FileLock struct {
 	*SafeLock
 
 	filename string
+	lockDir  string
 	fs       afero.Fs
 }
 
+var _ SafeLockiface = (*FileLock)(nil)
+
 // NewFileLock creates a new instance of FileLock
 func NewFileLock(node uint16, filename string, fs afero.Fs) *FileLock {
 	return &FileLock{
@@ -34,105 +37,195 @@ func NewFileLock(node uint16, filename string, fs afero.Fs) *FileLock {
 }
 
 // Lock will lock
+// Transient filesystem errors are retried, see SetRetryBackoff.
 func (l *FileLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext will lock, the context carries the span of the operation and cancels requests to the backend
+func (l *FileLock) LockContext(ctx context.Context) error {
+	ev := l.newLockEvents(ctx, "Lock", l.GetLockURI())
+	start := l.GetClock().Now()
+	errLock := l.lock(ev)
+	ev.acquired(start, errLock)
+	return errLock
+}
+
+// lock writes the lock file, taking over a lock that has expired or, in OwnershipModeNode, was held by a prior session of this node
+func (l *FileLock) lock(ev *lockEvents) error {
+	ctx := ev.ctx
+
+	if errSessionID := l.checkSessionID(); errSessionID != nil {
+		return errSessionID
+	}
 
 	// Check first if the lock exists
 	// For FileLock the error is never used and the state can only be locked/unlocked
-	lockState, _ := l.GetLockState()
+	lockState, _ := l.GetLockStateContext(ctx)
 	if lockState == LockStateLocked {
-		// conditionally handle deadlock if the lock exists and is owned by a prior session of the same node
+		// conditionally handle deadlock if the lock exists and has expired or is owned by a prior session of the same node
 
 		// check the ownership of the lock
-		ownedNode, ownedSession, expired, err := l.lockStatus()
+		ownedNode, ownedSession, expired, held, err := l.lockStatus()
 		if err != nil {
 			return fmt.Errorf("failed to check lock ownership: %v", err)
 		}
 
 		// release a deadlocked file lock
-		if (ownedNode && !ownedSession) || expired {
-			l.mu.Lock()
-			// remove file system lock
-			err := l.fs.Remove(l.GetLockFilename())
-			if err != nil {
-				l.mu.Unlock()
-				return err
+		if l.isAcquirable(ownedNode, ownedSession, expired) {
+			ev.takingOver(held, expired)
+			// remove file system lock, unless it was replaced by another session
+			errRemove := l.retry(ev, isRetryableFileError, l.removeLock,
+				unlockSettled(ctx, l.currentLock, held, l.errLocked()))
+			if errRemove != nil {
+				return errRemove
 			}
-			l.mu.Unlock()
 		} else {
-			return fmt.Errorf("the object at %s is locked", l.GetFilename())
+			ev.holder = held
+			return l.errLocked()
 		}
 	}
 
-	// Lock after getting the lock state
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	// Write object to S3
-	aFile, errOpen := l.fs.OpenFile(l.GetLockFilename(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if errOpen != nil {
-		return fmt.Errorf("unable to open %q: %w", l.GetLockFilename(), errOpen)
+	// The same body is written by every attempt so a retry can tell whether an earlier attempt took effect
+	body := l.GetLockBody()
+	written, errParse := parseLockBody(body)
+	if errParse != nil {
+		return errParse
 	}
-	defer aFile.Close()
 
-	_, errWrite := aFile.Write(l.GetLockBody())
-	if errWrite != nil {
-		return fmt.Errorf("unable to write data to %q: %w", l.GetLockFilename(), errWrite)
-	}
-	return nil
+	return l.retry(ev, isRetryableFileError, func() error {
+		// Lock after getting the lock state
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.lockDir) > 0 {
+			if errMkdir := l.fs.MkdirAll(l.lockDir, 0755); errMkdir != nil {
+				return fmt.Errorf("unable to create lock directory %q: %w", l.lockDir, errMkdir)
+			}
+		}
+
+		// Write object to S3
+		aFile, errOpen := l.fs.OpenFile(l.GetLockFilename(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if errOpen != nil {
+			return fmt.Errorf("unable to open %q: %w", l.GetLockFilename(), errOpen)
+		}
+		defer aFile.Close()
+
+		_, errWrite := aFile.Write(body)
+		if errWrite != nil {
+			return fmt.Errorf("unable to write data to %q: %w", l.GetLockFilename(), errWrite)
+		}
+		return nil
+	}, lockSettled(ctx, l.currentLock, written, l.errLocked()))
 }
 
 // Unlock will unlock
+// Transient filesystem errors are retried, see SetRetryBackoff.
 func (l *FileLock) Unlock() error {
+	return l.UnlockContext(context.Background())
+}
 
-	// Check first if the lock exists
-	// For FileLock the error is never used and the state can only be locked/unlocked
-	lockState, _ := l.GetLockState()
+// UnlockContext will unlock, the context carries the span of the operation and cancels requests to the backend
+func (l *FileLock) UnlockContext(ctx context.Context) error {
+	ev := l.newLockEvents(ctx, "Unlock", l.GetLockURI())
+	held, errUnlock := l.unlock(ev)
+	ev.released(held, false, errUnlock)
+	return errUnlock
+}
+
+// unlock removes the lock if it is owned by this session and returns the lock that was removed
+func (l *FileLock) unlock(ev *lockEvents) (*lockBody, error) {
+	ctx := ev.ctx
+
+	// Check first if the lock exists, the lock is only not locked if the lock file does not exist
+	lockState, errLockState := l.GetLockStateContext(ctx)
+	if errLockState != nil {
+		return nil, errLockState
+	}
 	if lockState == LockStateUnlocked {
-		return fmt.Errorf("the object at %s is not locked", l.GetFilename())
+		return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetFilename(), ErrNotLocked)
 	}
 
 	// Validate that the lock belongs to this code
-	ownedNode, _, expired, errIsSameLock := l.lockStatus()
+	ownedNode, ownedSession, expired, held, errIsSameLock := l.lockStatus()
 	if errIsSameLock != nil {
-		return fmt.Errorf("unable to determine if lock is the same lock: %w", errIsSameLock)
+		return nil, fmt.Errorf("unable to determine if lock is the same lock: %w", errIsSameLock)
 	}
 
-	if !ownedNode && !expired {
-		return fmt.Errorf("the existing lock is not managed by this process")
+	if errOwnership := l.checkOwnership(ownedNode, ownedSession, expired); errOwnership != nil {
+		return held, errOwnership
 	}
 
-	// Lock after verifying the state and lock contents
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	// Remove object from filesystem
-	errRemove := l.fs.Remove(l.GetLockFilename())
-	if errRemove != nil {
-		return errRemove
-	}
-	return nil
+	// Remove object from filesystem, a lock that was replaced since it was checked has been released
+	return held, l.retry(ev, isRetryableFileError, l.removeLock, unlockSettled(ctx, l.currentLock, held, nil))
 }
 
 // ForceUnlock will unlock despite ownership
+// Transient filesystem errors are retried, see SetRetryBackoff.
 func (l *FileLock) ForceUnlock() error {
+	return l.ForceUnlockContext(context.Background())
+}
 
-	// Check first if the lock exists
-	// For FileLock the error is never used and the state can only be locked/unlocked
-	lockState, _ := l.GetLockState()
+// ForceUnlockContext will unlock despite ownership, the context carries the span of the operation and
+// cancels requests to the backend
+func (l *FileLock) ForceUnlockContext(ctx context.Context) error {
+	ev := l.newLockEvents(ctx, "ForceUnlock", l.GetLockURI())
+	held, errUnlock := l.forceUnlock(ev)
+	ev.released(held, true, errUnlock)
+	return errUnlock
+}
+
+// forceUnlock removes the lock and returns the lock that was removed
+func (l *FileLock) forceUnlock(ev *lockEvents) (*lockBody, error) {
+	ctx := ev.ctx
+
+	// Check first if the lock exists, the lock is only not locked if the lock file does not exist
+	lockState, errLockState := l.GetLockStateContext(ctx)
+	if errLockState != nil {
+		return nil, errLockState
+	}
 	if lockState == LockStateUnlocked {
-		return fmt.Errorf("the object at %s is not locked", l.GetFilename())
+		return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetFilename(), ErrNotLocked)
 	}
 
+	// The lock is removed even if it can't be read
+	held, _, _ := l.readLock()
+
+	// Remove object from filesystem, a lock that was replaced since it was checked has been released
+	return held, l.retry(ev, isRetryableFileError, l.removeLock, unlockSettled(ctx, l.currentLock, held, nil))
+}
+
+// removeLock removes the lock file
+func (l *FileLock) removeLock() error {
 	// Lock after verifying the state and lock contents
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	// Remove object from filesystem
-	errRemove := l.fs.Remove(l.GetLockFilename())
-	if errRemove != nil {
-		return errRemove
+	return l.fs.Remove(l.GetLockFilename())
+}
+
+// currentLock reads the lock file before retrying an operation, returning nil if there is no lock file
+func (l *FileLock) currentLock(ctx context.Context) (*lockBody, error) {
+	lockState, errGetLockState := l.GetLockStateContext(ctx)
+	if errGetLockState != nil {
+		return nil, errGetLockState
+	}
+	if lockState == LockStateUnlocked {
+		return nil, nil
+	}
+	lockBody, _, errReadLock := l.readLock()
+	if errReadLock != nil {
+		if errors.Is(errReadLock, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errReadLock
 	}
-	return nil
+	return lockBody, nil
+}
+
+// errLocked returns the error for a lock held by another session
+func (l *FileLock) errLocked() error {
+	return fmt.Errorf("the object at %s is locked: %w", l.GetFilename(), ErrLocked)
 }
 
 // GetFilename will return the filename for the lock
@@ -142,12 +235,35 @@ func (l *FileLock) GetFilename() string {
 
 // GetLockFilename will return the filename for the lock object
 func (l *FileLock) GetLockFilename() string {
+	if len(l.lockDir) > 0 {
+		return lockDirFilename(l.lockDir, l.GetFilename(), l.GetLockSuffix())
+	}
 	lockPath := l.GetFilename() + l.GetLockSuffix()
 	return lockPath
 }
 
+// GetLockURI will return the file URI for the lock object
+func (l *FileLock) GetLockURI() string {
+	uri := url.URL{
+		Scheme: "file",
+		Path:   filepath.ToSlash(l.GetLockFilename()),
+	}
+	return uri.String()
+}
+
 // GetLockState returns the lock's state
 func (l *FileLock) GetLockState() (LockState, error) {
+	return l.GetLockStateContext(context.Background())
+}
+
+// GetLockStateContext returns the lock's state, the context carries the span of the operation
+func (l *FileLock) GetLockStateContext(ctx context.Context) (LockState, error) {
+	ev := l.newLockEvents(ctx, "GetLockState", l.GetLockURI())
+	return ev.checked(l.getLockState())
+}
+
+// getLockState returns the lock's state from the filesystem
+func (l *FileLock) getLockState() (LockState, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -165,79 +281,148 @@ func (l *FileLock) GetLockState() (LockState, error) {
 
 // lockStatus load the current state of the lock
 // Returns
-// 		nodeOwned 			- bool, whether the lock is owned by this node
-//		sessionOwned 		- bool, whether the lock is owned byt his session
-//		expired 			- bool, whether the lock has passed its expiration
-func (l *FileLock) lockStatus() (bool, bool, bool, error) {
+//
+//	nodeOwned 			- bool, whether the lock is owned by this node
+//	sessionOwned 		- bool, whether the lock is owned byt his session
+//	expired 			- bool, whether the lock has passed its expiration
+//	lockBody 			- *lockBody, the lock that was checked
+func (l *FileLock) lockStatus() (bool, bool, bool, *lockBody, error) {
+	lockBody, modified, errReadLock := l.readLock()
+	if errReadLock != nil {
+		return false, false, false, nil, errReadLock
+	}
+
+	ownedNode, ownedSession := l.lockOwnership(lockBody)
+	expired := l.isExpired(lockBody, modified)
+
+	return ownedNode, ownedSession, expired, lockBody, nil
+}
+
+// readLock reads and parses the lock file
+// Returns the parsed lock body and the time the lock was last modified
+func (l *FileLock) readLock() (*lockBody, time.Time, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	aFile, errOpen := l.fs.Open(l.GetLockFilename())
 	if errOpen != nil {
-		return false, false, false, fmt.Errorf("unable to open %q: %w", l.GetLockFilename(), errOpen)
+		return nil, time.Time{}, fmt.Errorf("unable to open %q: %w", l.GetLockFilename(), errOpen)
 	}
 	defer aFile.Close()
 
+	info, errStat := aFile.Stat()
+	if errStat != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to stat %q: %w", l.GetLockFilename(), errStat)
+	}
+
 	body, errRead := ioutil.ReadAll(aFile)
 	if errRead != nil {
-		return false, false, false, errRead
+		return nil, time.Time{}, errRead
 	}
 
-	// split the body into the node and id
-	parts := bytes.Split(body, []byte("__::__"))
-	if len(parts) != 3 {
-		return false, false, false, fmt.Errorf("incompatible lock file format")
+	// parse the body into the node, id and timestamps
+	lockBody, errParse := parseLockBody(body)
+	if errParse != nil {
+		return nil, time.Time{}, errParse
 	}
 
-	// set the default value for expiration to false
-	expired := false
+	// the modification time recorded by the filesystem is used for expiration, the
+	// timestamp in the lock file is only a fallback
+	modified := info.ModTime()
+	if modified.IsZero() {
+		modified = lockBody.timestamp
+	}
 
-	// handle timestamp if there is a configured timeout on the lock
-	if l.timeout > 0 {
-		// decode the timestamp from the third position in the lock file
-		ts := time.Unix(0, int64(binary.LittleEndian.Uint64(parts[2])))
+	return lockBody, modified, nil
+}
 
-		// update expired with the expiration status
-		expired = time.Since(ts) > l.timeout
+// GetOwner returns the current holder of the lock
+func (l *FileLock) GetOwner() (*LockOwner, error) {
+	return l.GetOwnerContext(context.Background())
+}
+
+// GetOwnerContext returns the current holder of the lock, the context carries the span of the operation
+// ErrNotLocked is only returned if the lock file does not exist, other errors are returned as is.
+func (l *FileLock) GetOwnerContext(ctx context.Context) (*LockOwner, error) {
+	lockState, errLockState := l.GetLockStateContext(ctx)
+	if errLockState != nil {
+		return nil, errLockState
+	}
+	if lockState == LockStateUnlocked {
+		return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetFilename(), ErrNotLocked)
 	}
 
-	return bytes.Equal(parts[0], l.GetNodeBytes()), bytes.Equal(parts[1], l.GetIDBytes()), expired, nil
+	lockBody, modified, errReadLock := l.readLock()
+	if errReadLock != nil {
+		// the lock file was removed since its state was checked
+		if errors.Is(errReadLock, os.ErrNotExist) {
+			return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetFilename(), ErrNotLocked)
+		}
+		return nil, fmt.Errorf("unable to read lock: %w", errReadLock)
+	}
+
+	return l.newLockOwner(lockBody, modified), nil
+}
+
+// IsHeld returns true if the lock is held by this session and has not expired
+func (l *FileLock) IsHeld() (bool, error) {
+	return l.IsHeldContext(context.Background())
+}
+
+// IsHeldContext returns true if the lock is held by this session and has not expired, the context
+// carries the span of the operation
+// The lock is only reported lost if it is missing or held by another session, errors checking it are returned.
+func (l *FileLock) IsHeldContext(ctx context.Context) (bool, error) {
+	owner, errGetOwner := l.GetOwnerContext(ctx)
+	if errGetOwner != nil {
+		if errors.Is(errGetOwner, ErrNotLocked) {
+			l.lost(ctx, l.GetLockURI(), nil, errGetOwner)
+			return false, nil
+		}
+		return false, errGetOwner
+	}
+	if !l.isOwner(owner) {
+		l.lost(ctx, l.GetLockURI(), owner, nil)
+		return false, nil
+	}
+	return true, nil
 }
 
-// WaitForLock waits until an object is no longer locked or cancels based on a timeout
+// WaitForLock waits until an object is no longer locked, or the existing lock has expired or, in
+// OwnershipModeNode, belongs to a prior session of this node, or cancels based on a timeout
+// On the local operating system filesystem the lock file is watched for removal so that
+// waiting ends as soon as the lock is released. Other filesystems poll the lock state.
 func (l *FileLock) WaitForLock(timeout time.Duration) error {
-	// Do not lock/unlock the struct here or it will block getting the lock state
+	return l.WaitForLockContext(context.Background(), timeout)
+}
 
-	// create variable to hold the context
-	var ctx context.Context
-	var cancel context.CancelFunc
+// WaitForLockContext waits until the lock is available, the context carries the span of the
+// operation and cancels waiting
+func (l *FileLock) WaitForLockContext(ctx context.Context, timeout time.Duration) error {
+	// Do not lock/unlock the struct here or it will block getting the lock state
+	ev := l.newLockEvents(ctx, "WaitForLock", l.GetLockURI())
 
-	// conditionally configure the context with a timeout
-	if timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), timeout)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+	// Watching is best effort, fall back to polling if the watcher can't be created
+	wake, stop, errWatch := l.watchLockFile()
+	if errWatch == nil {
+		defer stop()
 	}
 
-	defer cancel()
+	return l.waitFor(ev, timeout, l.GetBackoff(), func() (bool, error) {
+		lockState, errGetLockState := l.GetLockStateContext(ev.ctx)
+		if errGetLockState != nil {
+			return false, errGetLockState
+		}
+		if lockState == LockStateUnlocked {
+			return true, nil
+		}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("unable to obtain lock after %s: %w", l.GetTimeout(), ctx.Err())
-		default:
-			lockState, errGetLockState := l.GetLockState()
-			if errGetLockState != nil {
-				return errGetLockState
-			}
-			switch lockState {
-			case LockStateUnlocked:
-				return nil
-			case LockStateUnknown, LockStateLocked:
-				// Add jitter to the sleep of 1 second
-				r := rand.Intn(100)
-				time.Sleep(1*time.Second + time.Duration(r)*time.Millisecond)
-			}
+		// Lock will take over the existing lock if it is stale
+		ownedNode, ownedSession, expired, _, errLockStatus := l.lockStatus()
+		if errLockStatus != nil {
+			// The lock may have been removed or replaced since the state was checked
+			return false, nil
 		}
-	}
+		return l.isAcquirable(ownedNode, ownedSession, expired), nil
+	}, wake)
 }
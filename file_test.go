@@ -2,9 +2,11 @@ package safelock
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -40,9 +42,8 @@ func TestFileLock(t *testing.T) {
 	errUnlock := l.Unlock()
 	assert.NoError(t, errUnlock)
 
-	nodeCreation := time.Unix(0, int64(l.GetID()))
-	fmt.Println(time.Since(nodeCreation))
-	assert.True(t, time.Since(nodeCreation) < time.Second)
+	// The session id is a random version 7 UUID
+	assert.Equal(t, uuid.Version(7), l.GetSessionID().Version())
 
 	lockState, errGetLockState := l.GetLockState()
 	assert.NoError(t, errGetLockState)
@@ -153,12 +154,19 @@ func TestFileLockDeadlockRepair(t *testing.T) {
 
 	// attempt to unlock the prior sessions lock
 	err = l.Unlock()
-	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrWrongNode))
 
 	// create a new session with node 0 leaving the old lock on
 	l = NewFileLock(0, filename, fs)
 
 	// attempt to unlock the prior sessions lock
+	err = l.Unlock()
+	assert.True(t, errors.Is(err, ErrWrongSession))
+
+	// opt in to unlocking any session of the same node
+	l.SetOwnershipMode(OwnershipModeNode)
+	assert.Equal(t, OwnershipModeNode, l.GetOwnershipMode())
+
 	err = l.Unlock()
 	assert.NoError(t, err)
 
@@ -168,7 +176,12 @@ func TestFileLockDeadlockRepair(t *testing.T) {
 	// create a new session with node 0 leaving the old lock on
 	l = NewFileLock(0, filename, fs)
 
-	// attempt to lock on top of the old session
+	// strict ownership does not take over the lock of the old session
+	err = l.Lock()
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// opt in to taking over the lock of any session of the same node
+	l.SetOwnershipMode(OwnershipModeNode)
 	err = l.Lock()
 	assert.NoError(t, err)
 
@@ -181,18 +194,21 @@ func TestFileLockDeadlockRepair(t *testing.T) {
 
 	// create a new session with node 0 leaving the old lock on
 	l = NewFileLock(0, filename, fs)
+	l.SetOwnershipMode(OwnershipModeNode)
 
 	err = l.Unlock()
 	assert.NoError(t, err)
 
-	// create lock with node 0
+	// create lock with node 0 that expires after 2 seconds
 	l0 := NewFileLock(0, filename, fs)
+	l0.SetTimeout(time.Second * 2)
 
 	err = l0.Lock()
 	assert.NoError(t, err)
 
+	// node 1 trusts its clock to expire the lock without waiting for the skew allowance
 	l1 := NewFileLock(1, filename, fs)
-	l1.SetTimeout(time.Second * 2)
+	l1.SetClockSkew(0)
 
 	err = l1.Lock()
 	assert.Error(t, err)
@@ -230,3 +246,241 @@ func TestFileLock_ForceUnlock(t *testing.T) {
 	err = l.ForceUnlock()
 	assert.NoError(t, err)
 }
+
+func TestFileLockWaitWatch(t *testing.T) {
+
+	fs := afero.NewOsFs()
+	filename := filepath.Join(t.TempDir(), "file.txt")
+
+	l := NewFileLock(0, filename, fs)
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+
+	// Poll rarely so that only the watcher can end the wait quickly
+	l.SetBackoff(NewConstantBackoff(time.Minute, 0))
+
+	done := make(chan error)
+	go func() {
+		done <- l.WaitForLock(DefaultTimeout)
+	}()
+
+	// Wait long enough for the watcher to start
+	time.Sleep(100 * time.Millisecond)
+	errUnlock := l.Unlock()
+	assert.NoError(t, errUnlock)
+
+	select {
+	case errWaitForLock := <-done:
+		assert.NoError(t, errWaitForLock)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForLock did not return after the lock file was removed")
+	}
+}
+
+func TestFileLockWaitStale(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	filename := "file.txt"
+
+	// create lock with node 0 that expires quickly
+	l0 := NewFileLock(0, filename, fs)
+	l0.SetTimeout(200 * time.Millisecond)
+
+	err := l0.Lock()
+	assert.NoError(t, err)
+
+	// a new session of node 0 can take over the lock without waiting
+	l := NewFileLock(0, filename, fs)
+	l.SetOwnershipMode(OwnershipModeNode)
+	l.SetBackoff(NewConstantBackoff(time.Minute, 0))
+
+	err = l.WaitForLock(DefaultTimeout)
+	assert.NoError(t, err)
+
+	// node 1 waits until the lock expires
+	l1 := NewFileLock(1, filename, fs)
+	l1.SetBackoff(NewConstantBackoff(10*time.Millisecond, 0))
+
+	start := time.Now()
+	err = l1.WaitForLock(DefaultTimeout)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < DefaultTimeout)
+
+	err = l1.Lock()
+	assert.NoError(t, err)
+}
+
+func TestFileLockClock(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	filename := "file.txt"
+
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	// create lock with node 0
+	l0 := NewFileLock(0, filename, fs)
+	l0.SetClock(c)
+
+	err := l0.Lock()
+	assert.NoError(t, err)
+
+	// expiration uses the time recorded by the filesystem
+	err = fs.Chtimes(l0.GetLockFilename(), c.Now(), c.Now())
+	assert.NoError(t, err)
+
+	l1 := NewFileLock(1, filename, fs)
+	l1.SetClock(c)
+
+	// the lock has not expired yet
+	c.Advance(DefaultTimeout)
+	err = l1.Lock()
+	assert.Error(t, err)
+
+	// wait for the lock to expire without sleeping
+	done := make(chan error)
+	go func() {
+		done <- l1.WaitForLock(time.Hour)
+	}()
+	c.BlockUntil(1)
+	c.Advance(DefaultClockSkew + DefaultBackoffInterval + DefaultBackoffJitter)
+	assert.NoError(t, <-done)
+
+	err = l1.Lock()
+	assert.NoError(t, err)
+}
+
+func TestFileLockClockSkew(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	filename := "file.txt"
+
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	// node 0 has a clock that runs an hour fast
+	l0 := NewFileLock(0, filename, fs)
+	l0.SetClock(NewFakeClock(c.Now().Add(time.Hour)))
+
+	err := l0.Lock()
+	assert.NoError(t, err)
+
+	err = fs.Chtimes(l0.GetLockFilename(), c.Now(), c.Now())
+	assert.NoError(t, err)
+
+	// node 1 allows for its clock being a few seconds off from the filesystem by default
+	l1 := NewFileLock(1, filename, fs)
+	l1.SetClock(c)
+	assert.Equal(t, DefaultClockSkew, l1.GetClockSkew())
+
+	// the fast timestamp in the lock body does not keep the lock alive
+	c.Advance(DefaultTimeout + time.Second)
+	err = l1.Lock()
+	assert.Error(t, err)
+
+	// the lock expires once the skew allowance has passed
+	c.Advance(DefaultClockSkew)
+	err = l1.Lock()
+	assert.NoError(t, err)
+
+	// node 2 has a clock that runs a few seconds fast and can not take over the live lock by default
+	err = fs.Chtimes(l1.GetLockFilename(), c.Now(), c.Now())
+	assert.NoError(t, err)
+	l2 := NewFileLock(2, filename, fs)
+	l2.SetClock(NewFakeClock(c.Now().Add(DefaultTimeout + DefaultClockSkew - time.Second)))
+	err = l2.Lock()
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// without the allowance the fast clock expires the live lock
+	l2.SetClockSkew(0)
+	err = l2.Lock()
+	assert.NoError(t, err)
+}
+
+func TestFileLockOwner(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	filename := "file.txt"
+
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	l0 := NewFileLock(0, filename, fs)
+	l0.SetClock(c)
+
+	// nobody holds the lock
+	_, err := l0.GetOwner()
+	assert.True(t, errors.Is(err, ErrNotLocked))
+	held, err := l0.IsHeld()
+	assert.NoError(t, err)
+	assert.False(t, held)
+
+	err = l0.Lock()
+	assert.NoError(t, err)
+	err = fs.Chtimes(l0.GetLockFilename(), c.Now(), c.Now())
+	assert.NoError(t, err)
+
+	owner, err := l0.GetOwner()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), owner.Node)
+	assert.Equal(t, l0.GetID(), owner.ID)
+	assert.True(t, c.Now().Equal(owner.Acquired))
+	assert.True(t, c.Now().Add(DefaultTimeout).Equal(owner.ExpiresAt))
+	assert.False(t, owner.Expired)
+
+	owner, err = l0.GetOwnerContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, l0.GetSessionID(), owner.SessionID)
+
+	held, err = l0.IsHeldContext(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, held)
+
+	held, err = l0.IsHeld()
+	assert.NoError(t, err)
+	assert.True(t, held)
+
+	// another session sees the same owner but does not hold the lock
+	l1 := NewFileLock(1, filename, fs)
+	l1.SetClock(c)
+
+	owner, err = l1.GetOwner()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), owner.Node)
+	assert.Equal(t, l0.GetID(), owner.ID)
+
+	held, err = l1.IsHeld()
+	assert.NoError(t, err)
+	assert.False(t, held)
+
+	// the lock is no longer held once it expires
+	c.Advance(DefaultTimeout + DefaultClockSkew + time.Second)
+	held, err = l0.IsHeld()
+	assert.NoError(t, err)
+	assert.False(t, held)
+}
+
+func TestFileLockNodeName(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	filename := "file.txt"
+
+	// two hosts that were both assigned node 0
+	la := NewFileLock(0, filename, fs)
+	assert.NoError(t, la.SetNodeName("host-a"))
+	lb := NewFileLock(0, filename, fs)
+	assert.NoError(t, lb.SetNodeName("host-b"))
+	lb.SetOwnershipMode(OwnershipModeNode)
+
+	err := la.Lock()
+	assert.NoError(t, err)
+
+	owner, err := lb.GetOwner()
+	assert.NoError(t, err)
+	assert.Equal(t, "host-a", owner.NodeName)
+
+	// the names are compared instead of the node numbers
+	err = lb.Lock()
+	assert.Error(t, err)
+	err = lb.Unlock()
+	assert.True(t, errors.Is(err, ErrWrongNode))
+
+	// a reader without a node name compares the node numbers
+	legacy := NewFileLock(0, filename, fs)
+	legacy.SetOwnershipMode(OwnershipModeNode)
+	err = legacy.Unlock()
+	assert.NoError(t, err)
+}
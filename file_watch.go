@@ -0,0 +1,74 @@
+package safelock
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+)
+
+// errWatchUnsupported is returned when the filesystem can't be watched for changes
+var errWatchUnsupported = errors.New("filesystem does not support watching")
+
+// watchLockFile watches the directory of the lock file and signals on the returned channel
+// whenever the lock file is removed or renamed. The returned function stops the watcher.
+func (l *FileLock) watchLockFile() (<-chan struct{}, func(), error) {
+	// Only the operating system filesystem can be watched with inotify and friends
+	if _, ok := l.fs.(*afero.OsFs); !ok {
+		return nil, nil, errWatchUnsupported
+	}
+
+	watcher, errWatcher := fsnotify.NewWatcher()
+	if errWatcher != nil {
+		return nil, nil, fmt.Errorf("unable to create watcher: %w", errWatcher)
+	}
+
+	// Watch the directory because the lock file itself may not exist yet
+	lockFilename := filepath.Clean(l.GetLockFilename())
+	errAdd := watcher.Add(filepath.Dir(lockFilename))
+	if errAdd != nil {
+		_ = watcher.Close()
+		return nil, nil, fmt.Errorf("unable to watch %q: %w", filepath.Dir(lockFilename), errAdd)
+	}
+
+	// Buffer a single wakeup so that a removal between checks is not missed
+	wake := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != lockFilename {
+					continue
+				}
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			case _, ok := <-watcher.Errors:
+				// Errors are ignored because polling continues as a fallback
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		_ = watcher.Close()
+	}
+
+	return wake, stop, nil
+}
@@ -0,0 +1,57 @@
+package safelock
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+)
+
+// newSessionID generates a version 7 UUID, which orders by creation time and has 74 random bits read from random
+func newSessionID(clock Clock, random io.Reader) (uuid.UUID, error) {
+	var id uuid.UUID
+	if _, errRead := io.ReadFull(random, id[:]); errRead != nil {
+		return uuid.Nil, fmt.Errorf("unable to generate session id: %w", errRead)
+	}
+
+	// 48 bit big endian unix timestamp in milliseconds
+	ms := uint64(clock.Now().UnixNano() / 1e6)
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+
+	// version 7 and the RFC 4122 variant
+	id[6] = (id[6] & 0x0f) | 0x70
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return id, nil
+}
+
+// sessionIDFromUint64 converts an id set with SetID or read from an 8 byte lock file id into a session id
+// The id is stored little endian in the last 8 bytes and the first 8 bytes are zero.
+func sessionIDFromUint64(id uint64) uuid.UUID {
+	var sessionID uuid.UUID
+	binary.LittleEndian.PutUint64(sessionID[8:], id)
+	return sessionID
+}
+
+// sessionIDToUint64 returns the last 8 bytes of the session id as a little endian uint64
+// This is the inverse of sessionIDFromUint64.
+func sessionIDToUint64(sessionID uuid.UUID) uint64 {
+	return binary.LittleEndian.Uint64(sessionID[8:])
+}
+
+// parseSessionID parses the id field of a lock file, which is 16 bytes or 8 bytes for older lock files
+func parseSessionID(buf []byte) (uuid.UUID, error) {
+	switch len(buf) {
+	case 16:
+		return uuid.FromBytes(buf)
+	case 8:
+		return sessionIDFromUint64(binary.LittleEndian.Uint64(buf)), nil
+	}
+	return uuid.Nil, fmt.Errorf("incorrect buffer length for serialized id: %d != 16", len(buf))
+}
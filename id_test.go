@@ -0,0 +1,81 @@
+package safelock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSessionID(t *testing.T) {
+
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	// Session ids created in the same tick are still unique
+	seen := map[uuid.UUID]bool{}
+	for i := 0; i < 1000; i++ {
+		id, errSessionID := newSessionID(c, rand.Reader)
+		assert.NoError(t, errSessionID)
+		assert.Equal(t, uuid.Version(7), id.Version())
+		assert.Equal(t, uuid.RFC4122, id.Variant())
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+
+	// Session ids order by creation time
+	first, _ := newSessionID(c, rand.Reader)
+	c.Advance(time.Millisecond)
+	second, _ := newSessionID(c, rand.Reader)
+	assert.True(t, bytes.Compare(first[:], second[:]) < 0)
+
+	// A failure to read random bytes is returned
+	_, errSessionID := newSessionID(c, iotest.ErrReader(errors.New("no entropy")))
+	assert.Error(t, errSessionID)
+}
+
+func TestSessionIDError(t *testing.T) {
+
+	// Lock fails while there is no session id
+	l := NewFileLock(0, "file.txt", afero.NewMemMapFs())
+	l.SetSessionID(uuid.Nil)
+	l.errSessionID = errors.New("unable to generate session id")
+	assert.Equal(t, l.errSessionID, l.Lock())
+
+	// Setting a session id recovers
+	l.SetSessionID(uuid.New())
+	assert.NoError(t, l.Lock())
+}
+
+func TestSessionIDUint64(t *testing.T) {
+
+	// SetID round trips through the session id
+	l := NewSafeLock(0)
+	l.SetID(37583)
+	assert.Equal(t, uint64(37583), l.GetID())
+	assert.Equal(t, sessionIDFromUint64(37583), l.GetSessionID())
+
+	// Lock files written before session ids were widened have 8 byte ids
+	legacy := make([]byte, 8)
+	binary.LittleEndian.PutUint64(legacy, 37583)
+	id, errParse := parseSessionID(legacy)
+	assert.NoError(t, errParse)
+	assert.Equal(t, l.GetSessionID(), id)
+
+	// SetIDBytes accepts both forms
+	sessionID := uuid.New()
+	l.SetSessionID(sessionID)
+	assert.Equal(t, sessionID, l.GetSessionID())
+	assert.NoError(t, l.SetIDBytes(legacy))
+	assert.Equal(t, uint64(37583), l.GetID())
+	assert.NoError(t, l.SetIDBytes(sessionID[:]))
+	assert.Equal(t, sessionID, l.GetSessionID())
+	assert.Equal(t, sessionID[:], l.GetIDBytes())
+	assert.Error(t, l.SetIDBytes([]byte{1, 2, 3}))
+}
@@ -0,0 +1,26 @@
+package mocks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// MockIMDSClient is a mock AWS EC2 instance metadata service Client
+type MockIMDSClient struct {
+	// Output Data
+	Metadata map[string]string
+}
+
+func (s *MockIMDSClient) GetMetadata(ctx context.Context, params *imds.GetMetadataInput, optFns ...func(*imds.Options)) (*imds.GetMetadataOutput, error) {
+	content, ok := s.Metadata[params.Path]
+	if !ok {
+		return nil, errors.New("error from get metadata")
+	}
+	return &imds.GetMetadataOutput{
+		Content: ioutil.NopCloser(strings.NewReader(content)),
+	}, nil
+}
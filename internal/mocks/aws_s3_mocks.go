@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // MockS3Client is a mock AWS S3 Client
@@ -12,14 +13,38 @@ type MockS3Client struct {
 	S3uri *string
 
 	// Output Data
+	DeleteObjectInput  *s3.DeleteObjectInput
 	DeleteObjectOutput *s3.DeleteObjectOutput
+	GetObjectInput     *s3.GetObjectInput
 	GetObjectOutput    *s3.GetObjectOutput
+	HeadObjectInput    *s3.HeadObjectInput
 	HeadObjectOutput   *s3.HeadObjectOutput
 	PutObjectInput     *s3.PutObjectInput
+	PutObjectOptFns    []func(*s3.Options)
 	PutObjectOutput    *s3.PutObjectOutput
+
+	GetBucketVersioningOutput *s3.GetBucketVersioningOutput
+
+	PutObjectLegalHoldInput  *s3.PutObjectLegalHoldInput
+	PutObjectLegalHoldOutput *s3.PutObjectLegalHoldOutput
+	PutObjectRetentionInput  *s3.PutObjectRetentionInput
+	PutObjectRetentionOutput *s3.PutObjectRetentionOutput
+
+	// DeleteObjectFunc replaces the output of DeleteObject if set
+	DeleteObjectFunc func(params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	// HeadObjectFunc replaces the output of HeadObject if set
+	HeadObjectFunc func(params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	// PutObjectFunc replaces the output of PutObject if set
+	PutObjectFunc func(params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	// PutObjectLegalHoldFunc replaces the output of PutObjectLegalHold if set
+	PutObjectLegalHoldFunc func(params *s3.PutObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error)
 }
 
 func (s *MockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	s.DeleteObjectInput = params
+	if s.DeleteObjectFunc != nil {
+		return s.DeleteObjectFunc(params, optFns...)
+	}
 	if s.DeleteObjectOutput == nil {
 		return nil, errors.New("delete object error")
 	}
@@ -27,23 +52,58 @@ func (s *MockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObject
 }
 
 func (s *MockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	s.GetObjectInput = params
 	if s.GetObjectOutput == nil {
-		return nil, errors.New("error from get object")
+		return nil, &types.NoSuchKey{}
 	}
 	return s.GetObjectOutput, nil
 }
 
 func (s *MockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	s.HeadObjectInput = params
+	if s.HeadObjectFunc != nil {
+		return s.HeadObjectFunc(params, optFns...)
+	}
 	if s.HeadObjectOutput == nil {
-		return nil, errors.New("error from head object")
+		return nil, &types.NotFound{}
 	}
 	return s.HeadObjectOutput, nil
 }
 
 func (s *MockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
 	s.PutObjectInput = params
+	s.PutObjectOptFns = optFns
+	if s.PutObjectFunc != nil {
+		return s.PutObjectFunc(params, optFns...)
+	}
 	if s.PutObjectOutput == nil {
 		return nil, errors.New("error from put object")
 	}
 	return s.PutObjectOutput, nil
 }
+
+func (s *MockS3Client) PutObjectLegalHold(ctx context.Context, params *s3.PutObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+	s.PutObjectLegalHoldInput = params
+	if s.PutObjectLegalHoldFunc != nil {
+		return s.PutObjectLegalHoldFunc(params, optFns...)
+	}
+	if s.PutObjectLegalHoldOutput == nil {
+		return nil, errors.New("error from put object legal hold")
+	}
+	return s.PutObjectLegalHoldOutput, nil
+}
+
+func (s *MockS3Client) PutObjectRetention(ctx context.Context, params *s3.PutObjectRetentionInput, optFns ...func(*s3.Options)) (*s3.PutObjectRetentionOutput, error) {
+	s.PutObjectRetentionInput = params
+	if s.PutObjectRetentionOutput == nil {
+		return nil, errors.New("error from put object retention")
+	}
+	return s.PutObjectRetentionOutput, nil
+}
+
+func (s *MockS3Client) GetBucketVersioning(ctx context.Context, params *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	if s.GetBucketVersioningOutput == nil {
+		return nil, errors.New("error from get bucket versioning")
+	}
+	return s.GetBucketVersioningOutput, nil
+}
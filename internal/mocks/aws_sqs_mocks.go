@@ -0,0 +1,61 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// MockSQSClient is a mock AWS SQS Client
+type MockSQSClient struct {
+	// Messages are returned by ReceiveMessage one at a time, which blocks until one is sent
+	Messages chan types.Message
+
+	mu       sync.Mutex
+	deleted  []string
+	released []string
+}
+
+func (s *MockSQSClient) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if params.VisibilityTimeout == 0 {
+		s.released = append(s.released, aws.ToString(params.ReceiptHandle))
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// Released returns the receipt handles of the messages made visible again at once
+func (s *MockSQSClient) Released() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.released...)
+}
+
+func (s *MockSQSClient) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// Deleted returns the receipt handles of the deleted messages
+func (s *MockSQSClient) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+func (s *MockSQSClient) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case message := <-s.Messages:
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{message},
+		}, nil
+	}
+}
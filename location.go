@@ -0,0 +1,123 @@
+package safelock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// S3LockLocator returns the bucket and key of the lock object for an object
+// The suffix is the lock suffix of the lock, which locators should append to the key.
+type S3LockLocator func(bucket, key, suffix string) (string, string)
+
+// S3LockBesideObject locates the lock object next to the object, in the same bucket
+// This is the default.
+func S3LockBesideObject() S3LockLocator {
+	return func(bucket, key, suffix string) (string, string) {
+		return bucket, key + suffix
+	}
+}
+
+// S3LockInBucket locates the lock object in a separate lock bucket, at the object's bucket and key
+// For example, the lock object of s3://data/key is s3://lockBucket/data/key.lock, so objects with the
+// same key in different buckets have different lock objects.
+func S3LockInBucket(lockBucket string) S3LockLocator {
+	return func(bucket, key, suffix string) (string, string) {
+		return lockBucket, bucket + "/" + key + suffix
+	}
+}
+
+// S3LockInPrefix locates the lock object under a dedicated prefix, named by a hash of the
+// object's bucket and key. The lock object is in the object's bucket if lockBucket is empty.
+// For example, with the prefix "locks/" the lock object is locks/<hash>.lock.
+func S3LockInPrefix(lockBucket, prefix string) S3LockLocator {
+	return func(bucket, key, suffix string) (string, string) {
+		b := lockBucket
+		if len(b) == 0 {
+			b = bucket
+		}
+		return b, prefix + hashLockName(bucket+"/"+key) + suffix
+	}
+}
+
+// GetS3LockLocator returns the locator of the lock object
+func (l *S3ObjectLock) GetS3LockLocator() S3LockLocator {
+	return l.s3LockLocator
+}
+
+// SetS3LockLocator sets the locator of the lock object, nil restores the default
+func (l *S3ObjectLock) SetS3LockLocator(locator S3LockLocator) {
+	if locator == nil {
+		locator = S3LockBesideObject()
+	}
+	l.s3LockLocator = locator
+}
+
+// WithS3LockLocator sets the locator of S3 lock objects
+func WithS3LockLocator(locator S3LockLocator) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 lock locators are not supported by %T", l)
+		}
+		if locator == nil {
+			return errors.New("S3 lock locator must not be nil")
+		}
+		s3l.SetS3LockLocator(locator)
+		lockBucket, lockPath := s3l.GetLockBucket(), s3l.GetLockPath()
+		if len(lockBucket) == 0 || len(lockPath) == 0 {
+			return errors.New("S3 lock locator returned an empty bucket or key")
+		}
+		if lockBucket == s3l.GetS3Bucket() && lockPath == s3l.GetS3Key() {
+			return errors.New("S3 lock locator must not return the locked object")
+		}
+		return nil
+	}
+}
+
+// GetLockDir returns the directory of the lock file, empty if the lock file is next to the file
+func (l *FileLock) GetLockDir() string {
+	return l.lockDir
+}
+
+// SetLockDir sets the directory of the lock file, which is named by the file's name and a hash
+// of its path. The directory is created when locking if it does not exist.
+// An empty directory restores the default of the lock file being next to the file.
+func (l *FileLock) SetLockDir(lockDir string) {
+	l.lockDir = lockDir
+}
+
+// WithLockDir sets the directory of lock files
+func WithLockDir(lockDir string) Option {
+	return func(l configurable) error {
+		fl, ok := l.(*FileLock)
+		if !ok {
+			return fmt.Errorf("lock directories are not supported by %T", l)
+		}
+		if len(lockDir) == 0 {
+			return errors.New("lock directory must not be empty")
+		}
+		fl.SetLockDir(lockDir)
+		return nil
+	}
+}
+
+// lockDirFilename returns the name of the lock file for a file in a separate lock directory
+// The hash of the absolute path keeps lock files of files with the same name in different directories
+// apart, and gives relative and absolute paths to the same file the same lock file.
+func lockDirFilename(lockDir, filename, suffix string) string {
+	path, errAbs := filepath.Abs(filename)
+	if errAbs != nil {
+		path = filepath.Clean(filename)
+	}
+	name := filepath.Base(filename) + "-" + hashLockName(path)[:16] + suffix
+	return filepath.Join(lockDir, name)
+}
+
+// hashLockName returns the hex encoded SHA-256 hash of the name of a locked object
+func hashLockName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+package safelock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3LockLocator(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	// The lock object is next to the object by default
+	l := NewS3ObjectLock(0, "bucket", "data/key", "kmsKeyArn", &svcS3)
+	assert.Equal(t, "bucket", l.GetLockBucket())
+	assert.Equal(t, "data/key.lock", l.GetLockPath())
+
+	// A separate lock bucket
+	l.SetS3LockLocator(S3LockInBucket("locks"))
+	assert.Equal(t, "locks", l.GetLockBucket())
+	assert.Equal(t, "bucket/data/key.lock", l.GetLockPath())
+	assert.Equal(t, "s3://locks/bucket/data/key.lock", l.GetLockURI())
+	assert.Equal(t, "s3://bucket/data/key", l.GetObjectURI())
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, "locks", *svcS3.PutObjectInput.Bucket)
+	assert.Equal(t, "bucket/data/key.lock", *svcS3.PutObjectInput.Key)
+
+	// Objects with the same key in different buckets have different lock objects in the lock bucket
+	other := NewS3ObjectLock(0, "other", "data/key", "kmsKeyArn", &svcS3)
+	other.SetS3LockLocator(S3LockInBucket("locks"))
+	assert.NotEqual(t, l.GetLockPath(), other.GetLockPath())
+
+	// A dedicated prefix
+	l.SetS3LockLocator(S3LockInPrefix("", "locks/"))
+	assert.Equal(t, "bucket", l.GetLockBucket())
+	assert.Equal(t, "locks/"+hashLockName("bucket/data/key")+".lock", l.GetLockPath())
+
+	// Objects with the same key in different buckets have different lock objects
+	other.SetS3LockLocator(S3LockInPrefix("locks", "locks/"))
+	l.SetS3LockLocator(S3LockInPrefix("locks", "locks/"))
+	assert.Equal(t, l.GetLockBucket(), other.GetLockBucket())
+	assert.NotEqual(t, l.GetLockPath(), other.GetLockPath())
+
+	// A custom function
+	l.SetS3LockLocator(func(bucket, key, suffix string) (string, string) {
+		return bucket + "-locks", strings.ToUpper(key) + suffix
+	})
+	assert.Equal(t, "bucket-locks", l.GetLockBucket())
+	assert.Equal(t, "DATA/KEY.lock", l.GetLockPath())
+
+	// Restore the default
+	l.SetS3LockLocator(nil)
+	assert.Equal(t, "data/key.lock", l.GetLockPath())
+}
+
+func TestWithS3LockLocatorErrors(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{}
+
+	tests := []S3LockLocator{
+		nil,
+		func(bucket, key, suffix string) (string, string) { return "", key + suffix },
+		func(bucket, key, suffix string) (string, string) { return bucket, key },
+	}
+	for _, locator := range tests {
+		_, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3LockLocator(locator))
+		assert.Error(t, errNew)
+	}
+
+	_, errNew := NewFileLockWithOptions(0, "file.txt", afero.NewMemMapFs(), WithS3LockLocator(S3LockBesideObject()))
+	assert.Error(t, errNew)
+}
+
+func TestFileLockDir(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+
+	l, errNew := NewFileLockWithOptions(0, "/data/file.txt", fs, WithLockDir("/locks"))
+	assert.NoError(t, errNew)
+	assert.Equal(t, "/locks", l.GetLockDir())
+	assert.True(t, strings.HasPrefix(l.GetLockFilename(), "/locks/file.txt-"))
+	assert.True(t, strings.HasSuffix(l.GetLockFilename(), ".lock"))
+	assert.Equal(t, "file://"+l.GetLockFilename(), l.GetLockURI())
+
+	// The lock directory is created when locking
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	exists, errExists := afero.Exists(fs, l.GetLockFilename())
+	assert.NoError(t, errExists)
+	assert.True(t, exists)
+
+	lockState, errGetLockState := l.GetLockState()
+	assert.NoError(t, errGetLockState)
+	assert.Equal(t, LockStateLocked, lockState)
+
+	// Files with the same name in different directories have different lock files
+	other := NewFileLock(0, "/other/file.txt", fs)
+	other.SetLockDir("/locks")
+	assert.NotEqual(t, l.GetLockFilename(), other.GetLockFilename())
+
+	// Relative and absolute paths to the same file have the same lock file
+	wd, errGetwd := os.Getwd()
+	assert.NoError(t, errGetwd)
+	relative := NewFileLock(0, "data/file.txt", fs)
+	relative.SetLockDir("/locks")
+	absolute := NewFileLock(0, filepath.Join(wd, "data", "file.txt"), fs)
+	absolute.SetLockDir("/locks")
+	assert.Equal(t, relative.GetLockFilename(), absolute.GetLockFilename())
+
+	errUnlock := l.Unlock()
+	assert.NoError(t, errUnlock)
+
+	// Restore the default
+	l.SetLockDir("")
+	assert.Equal(t, "/data/file.txt.lock", l.GetLockFilename())
+
+	_, errNew = NewFileLockWithOptions(0, "file.txt", fs, WithLockDir(""))
+	assert.Error(t, errNew)
+}
+
+func TestOpenLockLocation(t *testing.T) {
+
+	l, errOpen := Open(context.Background(), "s3://bucket/key?lockbucket=locks", OpenOptions{S3Client: &mocks.MockS3Client{}})
+	assert.NoError(t, errOpen)
+	assert.Equal(t, "s3://locks/bucket/key.lock", l.GetLockURI())
+
+	l, errOpen = Open(context.Background(), "file:///data/file.txt?lockdir=/locks", OpenOptions{Fs: afero.NewMemMapFs()})
+	assert.NoError(t, errOpen)
+	assert.Equal(t, "/locks", l.(*FileLock).GetLockDir())
+}
@@ -1,14 +1,40 @@
 package safelock
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LockState string
 
+// OwnershipMode determines what Unlock requires to match before releasing a lock and whether Lock
+// may take over a lock held by another session of the same node
+type OwnershipMode string
+
+// ErrNotLocked is returned when an operation requires the object to be locked and the backend
+// confirmed that the lock does not exist, errors checking the lock are returned as is
+var ErrNotLocked = errors.New("not locked")
+
+// ErrLocked is returned by Lock when the lock is held by another session
+var ErrLocked = errors.New("locked")
+
+// ErrWrongNode is returned by Unlock when the lock is held by a different node
+var ErrWrongNode = errors.New("lock is held by a different node")
+
+// ErrWrongSession is returned by Unlock when the lock is held by a different session of the same node
+var ErrWrongSession = errors.New("lock is held by a different session")
+
 const (
 	// LockStateLocked is the locked state
 	LockStateLocked LockState = "locked"
@@ -17,34 +43,92 @@ const (
 	// LockStateUnknown is the unknown lock state
 	LockStateUnknown LockState = "unknown"
 
+	// OwnershipModeStrict requires both the node and the session id to match, so only an expired
+	// lock of another session may be taken over
+	OwnershipModeStrict OwnershipMode = "strict"
+	// OwnershipModeNode only requires the node to match, so any session of the node may unlock and
+	// a lock held by a prior session of the node may be taken over
+	OwnershipModeNode OwnershipMode = "node"
+
 	// DefaultTimeout is the default timeout used for locks
 	DefaultTimeout time.Duration = 30 * time.Second
 
 	// DefaultSuffix is the default lock suffix used for locks
 	DefaultSuffix = ".lock"
+
+	// MaxNodeNameLength is the maximum length of a node name in bytes
+	MaxNodeNameLength = 1024
+
+	// DefaultClockSkew is the default allowance for clock skew between nodes and storage when checking expiration
+	// A lock is only taken over once it has been expired for longer than the allowance, so a node whose
+	// clock runs up to this much fast can not take over a live lock.
+	DefaultClockSkew time.Duration = 5 * time.Second
+
+	// DefaultMaxTTL is the default maximum time to live accepted from a lock holder, zero means no maximum
+	DefaultMaxTTL time.Duration = 0
+
+	// DefaultBackoffInterval is the default interval between checks of the lock state
+	DefaultBackoffInterval time.Duration = 1 * time.Second
+
+	// DefaultBackoffJitter is the default maximum jitter added to the backoff interval
+	DefaultBackoffJitter time.Duration = 100 * time.Millisecond
 )
 
 // SafeLockiface is an interface for all implementations of locks
 type SafeLockiface interface {
 	Lock() error
+	LockContext(context.Context) error
 	Unlock() error
+	UnlockContext(context.Context) error
 	ForceUnlock() error
+	ForceUnlockContext(context.Context) error
 	GetID() uint64
+	GetSessionID() uuid.UUID
 	GetNode() uint16
 	GetIDBytes() []byte
 	GetNodeBytes() []byte
 	SetID(uint64)
+	SetSessionID(uuid.UUID)
 	SetNode(uint16)
+	GetNodeName() string
+	SetNodeName(string) error
 	SetIDBytes([]byte) error
 	SetNodeBytes([]byte) error
 	GetLockBody() []byte
 	GetLockState() (LockState, error)
+	GetLockStateContext(context.Context) (LockState, error)
+	GetOwner() (*LockOwner, error)
+	GetOwnerContext(context.Context) (*LockOwner, error)
+	IsHeld() (bool, error)
+	IsHeldContext(context.Context) (bool, error)
 	GetLockURI() string
 	GetLockSuffix() string
 	SetLockSuffix(string)
 	GetTimeout() time.Duration
 	SetTimeout(time.Duration)
 	WaitForLock(time.Duration) error
+	WaitForLockContext(context.Context, time.Duration) error
+}
+
+// LockOwner describes the current holder of a lock
+type LockOwner struct {
+	// Node is the node number of the holder
+	Node uint16
+	// NodeName is the node name of the holder, it is empty if the holder did not have a node name
+	NodeName string
+	// ID is the id of the holder's session as returned by GetID
+	ID uint64
+	// SessionID is the id of the holder's session
+	SessionID uuid.UUID
+	// Acquired is when the holder wrote the lock, according to the holder's clock
+	Acquired time.Time
+	// ExpiresAt is when the holder intends the lock to expire, according to the holder's clock
+	// It is zero if the lock never expires or the holder did not record an expiration.
+	ExpiresAt time.Time
+	// Hostname is the host of the holder, it is empty if the lock does not record it
+	Hostname string
+	// Expired is whether the lock has passed its expiration, as judged by this lock
+	Expired bool
 }
 
 // SafeLock manages the internal locking and metadata for locks
@@ -52,19 +136,52 @@ type SafeLock struct {
 	// This lock is internal to prevent two operations happening at the same time on this lock
 	mu sync.Mutex
 
-	node       uint16
-	id         uint64
-	lockSuffix string
-	timeout    time.Duration
+	node           uint16
+	nodeName       string
+	id             uuid.UUID
+	errSessionID   error
+	lockSuffix     string
+	timeout        time.Duration
+	backoff        Backoff
+	retryBackoff   Backoff
+	logger         *slog.Logger
+	metrics        *Metrics
+	clock          Clock
+	clockSkew      time.Duration
+	maxTTL         time.Duration
+	ownership      OwnershipMode
+	tracerProvider trace.TracerProvider
+
+	observersMu sync.RWMutex
+	observers   []LockObserver
+	// holding is whether the lock was acquired by this session and has not been released or lost
+	holding atomic.Bool
 }
 
+var _ SafeLockiface = (*SafeLock)(nil)
+
 // NewSafeLock creates a new instance of SafeLock
 func NewSafeLock(node uint16) *SafeLock {
+	return NewSafeLockWithClock(node, SystemClock{})
+}
+
+// NewSafeLockWithClock creates a new instance of SafeLock that uses the given clock
+// If a random session id can not be generated, Lock returns the error until a session id is set.
+func NewSafeLockWithClock(node uint16, clock Clock) *SafeLock {
+	id, errSessionID := newSessionID(clock, rand.Reader)
 	return &SafeLock{
-		node:       node,
-		id:         uint64(time.Now().UnixNano()),
-		timeout:    DefaultTimeout,
-		lockSuffix: DefaultSuffix,
+		node:         node,
+		id:           id,
+		errSessionID: errSessionID,
+		clock:        clock,
+		clockSkew:    DefaultClockSkew,
+		maxTTL:       DefaultMaxTTL,
+		ownership:    OwnershipModeStrict,
+		timeout:      DefaultTimeout,
+		lockSuffix:   DefaultSuffix,
+		backoff:      NewConstantBackoff(DefaultBackoffInterval, DefaultBackoffJitter),
+		retryBackoff: NewDefaultRetryBackoff(),
+		logger:       discardLogger,
 	}
 }
 
@@ -95,20 +212,46 @@ func (l *SafeLock) ForceUnlock() error {
 	return nil
 }
 
-// GetID returns the lock's id
+// LockContext will lock, the context carries the span of the operation and cancels requests to the backend
+func (l *SafeLock) LockContext(ctx context.Context) error {
+	return l.Lock()
+}
+
+// UnlockContext will unlock, the context carries the span of the operation and cancels requests to the backend
+func (l *SafeLock) UnlockContext(ctx context.Context) error {
+	return l.Unlock()
+}
+
+// ForceUnlockContext will unlock despite a lack of ownership, the context carries the span of the
+// operation and cancels requests to the backend
+func (l *SafeLock) ForceUnlockContext(ctx context.Context) error {
+	return l.ForceUnlock()
+}
+
+// GetID returns the lock's id as a uint64
+// This is the last 8 bytes of the session id, see GetSessionID for the full id.
+//
+// Deprecated: Session ids are 16 bytes and GetID does not identify the session, so SetID(GetID())
+// does not restore it. Use GetIDBytes or GetSessionID to persist the session id.
 func (l *SafeLock) GetID() uint64 {
+	return sessionIDToUint64(l.id)
+}
+
+// GetSessionID returns the lock's session id
+// By default this is a random version 7 UUID generated when the lock is created.
+func (l *SafeLock) GetSessionID() uuid.UUID {
 	return l.id
 }
 
 // GetNode returns the lock's node number
-func (l *SafeLock) GetNode() int {
-	return int(l.node)
+func (l *SafeLock) GetNode() uint16 {
+	return l.node
 }
 
-// GetIDBytes returns the lock's id in the form of a byte slice
+// GetIDBytes returns the lock's session id in the form of a byte slice
 func (l *SafeLock) GetIDBytes() []byte {
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, l.id)
+	b := make([]byte, 16)
+	copy(b, l.id[:])
 	return b
 }
 
@@ -119,22 +262,60 @@ func (l *SafeLock) GetNodeBytes() []byte {
 	return b
 }
 
-// SetID sets the lock's id
+// SetID sets the lock's id from a uint64
+// The session id is the uint64 in little endian in the last 8 bytes, which matches the
+// id recorded in lock files written before session ids were widened.
+//
+// Deprecated: The first 8 bytes of the session id are set to zero, so an id returned by GetID does
+// not restore the session. Use SetIDBytes or SetSessionID with the full session id.
 func (l *SafeLock) SetID(id uint64) {
+	l.id = sessionIDFromUint64(id)
+}
+
+// SetSessionID sets the lock's session id
+func (l *SafeLock) SetSessionID(id uuid.UUID) {
 	l.id = id
 }
 
+// checkSessionID returns an error if the lock has no session id because one could not be generated
+func (l *SafeLock) checkSessionID() error {
+	if l.id == uuid.Nil && l.errSessionID != nil {
+		return l.errSessionID
+	}
+	return nil
+}
+
 // SetNode sets the lock's node number
 func (l *SafeLock) SetNode(node uint16) {
 	l.node = node
 }
 
-// SetIDBytes sets the lock's id using a little-endian encoded uint32
+// GetNodeName returns the lock's node name
+func (l *SafeLock) GetNodeName() string {
+	return l.nodeName
+}
+
+// SetNodeName sets the lock's node name, which identifies the node more precisely than the node number
+// When both this lock and an existing lock have a node name, the names are compared instead of the numbers.
+// An empty name clears the node name.
+func (l *SafeLock) SetNodeName(nodeName string) error {
+	if len(nodeName) > MaxNodeNameLength {
+		return fmt.Errorf("node name is longer than %d bytes", MaxNodeNameLength)
+	}
+	if strings.Contains(nodeName, string(lockBodySeparator)) {
+		return fmt.Errorf("node name must not contain %q", lockBodySeparator)
+	}
+	l.nodeName = nodeName
+	return nil
+}
+
+// SetIDBytes sets the lock's session id from 16 bytes or a little-endian encoded uint64
 func (l *SafeLock) SetIDBytes(buf []byte) error {
-	if len(buf) != 8 {
-		return fmt.Errorf("incorrect buffer length for serialized id: %d != 8", len(buf))
+	id, errParse := parseSessionID(buf)
+	if errParse != nil {
+		return errParse
 	}
-	l.id = binary.LittleEndian.Uint64(buf)
+	l.id = id
 	return nil
 }
 
@@ -148,16 +329,25 @@ func (l *SafeLock) SetNodeBytes(buf []byte) error {
 }
 
 // GetLockBody returns the byte slice representation of the lock for the lock file
+// The body records when the lock was written and when the holder intends it to expire.
 func (l *SafeLock) GetLockBody() []byte {
-	// encode timestamp in little endian
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	now := l.GetClock().Now()
+
+	// a lock without a timeout never expires
+	var expiresAt time.Time
+	if l.timeout > 0 {
+		expiresAt = now.Add(l.timeout)
+	}
 
 	body := l.GetNodeBytes()
-	body = append(body, []byte("__::__")...)
+	body = append(body, lockBodySeparator...)
 	body = append(body, l.GetIDBytes()...)
-	body = append(body, []byte("__::__")...)
-	body = append(body, buf...)
+	body = append(body, lockBodySeparator...)
+	body = append(body, encodeTime(now)...)
+	body = append(body, lockBodySeparator...)
+	body = append(body, encodeTime(expiresAt)...)
+	body = append(body, lockBodySeparator...)
+	body = append(body, []byte(l.nodeName)...)
 	return body
 }
 
@@ -168,6 +358,34 @@ func (l *SafeLock) GetLockState() (LockState, error) {
 	return LockStateUnlocked, nil
 }
 
+// GetLockStateContext returns the lock's state, the context carries the span of the operation and
+// cancels requests to the backend
+func (l *SafeLock) GetLockStateContext(ctx context.Context) (LockState, error) {
+	return l.GetLockState()
+}
+
+// GetOwner returns the current holder of the lock
+func (l *SafeLock) GetOwner() (*LockOwner, error) {
+	return nil, ErrNotLocked
+}
+
+// GetOwnerContext returns the current holder of the lock, the context carries the span of the
+// operation and cancels requests to the backend
+func (l *SafeLock) GetOwnerContext(ctx context.Context) (*LockOwner, error) {
+	return l.GetOwner()
+}
+
+// IsHeld returns true if the lock is held by this session and has not expired
+func (l *SafeLock) IsHeld() (bool, error) {
+	return false, nil
+}
+
+// IsHeldContext returns true if the lock is held by this session and has not expired, the context
+// carries the span of the operation and cancels requests to the backend
+func (l *SafeLock) IsHeldContext(ctx context.Context) (bool, error) {
+	return l.IsHeld()
+}
+
 // GetLockURI will return the URI for the lock object
 func (l *SafeLock) GetLockURI() string {
 	return ""
@@ -193,8 +411,217 @@ func (l *SafeLock) SetTimeout(timeout time.Duration) {
 	l.timeout = timeout
 }
 
+// GetBackoff returns the backoff strategy used while waiting for the lock
+func (l *SafeLock) GetBackoff() Backoff {
+	return l.backoff
+}
+
+// SetBackoff sets the backoff strategy used while waiting for the lock
+// A nil backoff restores the default strategy.
+func (l *SafeLock) SetBackoff(backoff Backoff) {
+	if backoff == nil {
+		backoff = NewConstantBackoff(DefaultBackoffInterval, DefaultBackoffJitter)
+	}
+	l.backoff = backoff
+}
+
+// GetClock returns the clock used for timestamps, expiry and waiting
+func (l *SafeLock) GetClock() Clock {
+	return l.clock
+}
+
+// SetClock sets the clock used for timestamps, expiry and waiting
+// A nil clock restores the system clock.
+func (l *SafeLock) SetClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	l.clock = clock
+}
+
+// GetClockSkew returns the allowance for clock skew used when checking expiration
+func (l *SafeLock) GetClockSkew() time.Duration {
+	return l.clockSkew
+}
+
+// SetClockSkew sets the allowance for clock skew used when checking expiration
+// A lock is only considered expired once it is older than the timeout plus the allowance.
+func (l *SafeLock) SetClockSkew(clockSkew time.Duration) {
+	l.clockSkew = clockSkew
+}
+
+// GetMaxTTL returns the maximum time to live accepted from a lock holder
+func (l *SafeLock) GetMaxTTL() time.Duration {
+	return l.maxTTL
+}
+
+// SetMaxTTL sets the maximum time to live accepted from a lock holder
+// Locks held with a longer time to live, or none at all, are treated as expiring after the maximum.
+// A maximum of zero accepts any time to live.
+func (l *SafeLock) SetMaxTTL(maxTTL time.Duration) {
+	l.maxTTL = maxTTL
+}
+
+// GetOwnershipMode returns what Unlock requires to match before releasing a lock and whether Lock
+// may take over a lock held by a prior session of the node
+func (l *SafeLock) GetOwnershipMode() OwnershipMode {
+	return l.ownership
+}
+
+// SetOwnershipMode sets what Unlock requires to match before releasing a lock and whether Lock
+// may take over a lock held by a prior session of the node
+func (l *SafeLock) SetOwnershipMode(ownership OwnershipMode) {
+	l.ownership = ownership
+}
+
 // WaitForLock waits until an object is no longer locked or cancels based on a timeout
 func (l *SafeLock) WaitForLock(time.Duration) error {
 	// Do not lock/unlock the struct here or it will block getting the lock state
 	return nil
 }
+
+// WaitForLockContext waits until the lock is available, the context carries the span of the
+// operation and cancels waiting
+func (l *SafeLock) WaitForLockContext(ctx context.Context, timeout time.Duration) error {
+	return l.WaitForLock(timeout)
+}
+
+// isExpired returns true if a lock last modified at the given time, as recorded by the storage,
+// has passed the expiration intended by its holder. Lock files that don't record the holder's
+// intent expire based on this lock's timeout. Locks with no time to live never expire unless
+// there is a maximum time to live.
+func (l *SafeLock) isExpired(body *lockBody, modified time.Time) bool {
+	ttl, ok := body.ttl()
+	if !ok {
+		ttl = l.timeout
+	}
+
+	// the observer's policy limits how long a holder may keep the lock
+	if l.maxTTL > 0 && (ttl <= 0 || ttl > l.maxTTL) {
+		ttl = l.maxTTL
+	}
+
+	if ttl <= 0 {
+		return false
+	}
+	return l.GetClock().Now().Sub(modified) > ttl+l.clockSkew
+}
+
+// newLockOwner describes the holder of a lock from its lock body and the time the lock was last modified
+func (l *SafeLock) newLockOwner(body *lockBody, modified time.Time) *LockOwner {
+	return &LockOwner{
+		Node:      binary.LittleEndian.Uint16(body.node),
+		NodeName:  body.nodeName,
+		ID:        sessionIDToUint64(body.id),
+		SessionID: body.id,
+		Acquired:  body.timestamp,
+		ExpiresAt: body.expiresAt,
+		Hostname:  body.hostname,
+		Expired:   l.isExpired(body, modified),
+	}
+}
+
+// isOwner returns true if the owner is this session and the lock has not expired
+func (l *SafeLock) isOwner(owner *LockOwner) bool {
+	return l.isOwnerNode(owner.Node, owner.NodeName) && owner.SessionID == l.id && !owner.Expired
+}
+
+// isOwnerNode returns true if the node number and name identify this node
+// The names are compared if both are known, otherwise the numbers are compared.
+func (l *SafeLock) isOwnerNode(node uint16, nodeName string) bool {
+	if len(l.nodeName) > 0 && len(nodeName) > 0 {
+		return nodeName == l.nodeName
+	}
+	return node == l.node
+}
+
+// isOwnerSession returns true if a lock body was written by this session
+func (l *SafeLock) isOwnerSession(body *lockBody) bool {
+	_, ownedSession := l.lockOwnership(body)
+	return ownedSession
+}
+
+// lockOwnership returns whether a lock body is owned by this node and by this session
+func (l *SafeLock) lockOwnership(body *lockBody) (bool, bool) {
+	ownedNode := l.isOwnerNode(binary.LittleEndian.Uint16(body.node), body.nodeName)
+	ownedSession := ownedNode && body.id == l.id
+	return ownedNode, ownedSession
+}
+
+// checkOwnership returns an error if an existing lock may not be unlocked by this lock
+// Expired locks may always be unlocked.
+func (l *SafeLock) checkOwnership(ownedNode, ownedSession, expired bool) error {
+	if expired {
+		return nil
+	}
+	if !ownedNode {
+		return fmt.Errorf("the existing lock is not managed by this process: %w", ErrWrongNode)
+	}
+	if !ownedSession && l.ownership != OwnershipModeNode {
+		return fmt.Errorf("the existing lock is not managed by this session: %w", ErrWrongSession)
+	}
+	return nil
+}
+
+// isAcquirable returns true if an existing lock may be taken over because it has passed its
+// expiration or, in OwnershipModeNode, is owned by a prior session of the same node
+func (l *SafeLock) isAcquirable(ownedNode, ownedSession, expired bool) bool {
+	if expired {
+		return true
+	}
+	return l.ownership == OwnershipModeNode && ownedNode && !ownedSession
+}
+
+// waitFor calls check until it reports that the lock is available, sleeping between
+// attempts according to the backoff strategy, or cancels based on a timeout.
+// A receive on wake ends the current sleep early, wake may be nil.
+func (l *SafeLock) waitFor(ev *lockEvents, timeout time.Duration, backoff Backoff, check func() (bool, error), wake <-chan struct{}) error {
+	// Do not lock/unlock the struct here or it will block getting the lock state
+
+	clock := l.GetClock()
+	start := clock.Now()
+
+	// conditionally configure the deadline with a timeout
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = start.Add(timeout)
+	}
+	timedOut := func() bool {
+		return timeout > 0 && !clock.Now().Before(deadline)
+	}
+
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		if timedOut() {
+			return ev.waited(start, attempt-1, fmt.Errorf("unable to obtain lock after %s: %w", l.GetTimeout(), context.DeadlineExceeded))
+		}
+
+		available, errCheck := check()
+		if errCheck != nil {
+			return ev.waited(start, attempt, errCheck)
+		}
+		if available {
+			return ev.waited(start, attempt, nil)
+		}
+
+		next, ok := backoff.Next(attempt, delay)
+		if !ok {
+			return ev.waited(start, attempt, fmt.Errorf("unable to obtain lock after %d attempts: %w", attempt, ErrMaxAttemptsExceeded))
+		}
+		delay = next
+
+		// never sleep past the deadline
+		sleep := delay
+		if remaining := deadline.Sub(clock.Now()); timeout > 0 && remaining < sleep {
+			sleep = remaining
+		}
+
+		ev.waiting(attempt, sleep)
+		select {
+		case <-clock.After(sleep):
+		case <-wake:
+		case <-ev.ctx.Done():
+			return ev.waited(start, attempt, fmt.Errorf("stopped waiting for lock: %w", ev.ctx.Err()))
+		}
+	}
+}
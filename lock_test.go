@@ -1,9 +1,12 @@
 package safelock
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,8 +20,8 @@ func TestSafeLock(t *testing.T) {
 	errUnlock := l.Unlock()
 	assert.NoError(t, errUnlock)
 
-	nodeCreation := time.Unix(0, int64(l.GetID()))
-	assert.True(t, time.Since(nodeCreation) < time.Second)
+	// The session id is a random version 7 UUID
+	assert.Equal(t, uuid.Version(7), l.GetSessionID().Version())
 
 	lockState, errGetLockState := l.GetLockState()
 	assert.NoError(t, errGetLockState)
@@ -46,4 +49,22 @@ func TestSafeLock(t *testing.T) {
 	// SetID
 	l.SetID(37583)
 	assert.Equal(t, l.GetID(), uint64(37583))
+
+	// SetNode
+	l.SetNode(7)
+	assert.Equal(t, uint16(7), l.GetNode())
+
+	// SetNodeName
+	assert.NoError(t, l.SetNodeName("host-a"))
+	assert.Equal(t, "host-a", l.GetNodeName())
+	assert.Error(t, l.SetNodeName("host__::__a"))
+	assert.Error(t, l.SetNodeName(strings.Repeat("a", MaxNodeNameLength+1)))
+	assert.Equal(t, "host-a", l.GetNodeName())
+
+	// Owner
+	_, errGetOwner := l.GetOwner()
+	assert.True(t, errors.Is(errGetOwner, ErrNotLocked))
+	held, errIsHeld := l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.False(t, held)
 }
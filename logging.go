@@ -0,0 +1,86 @@
+package safelock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+)
+
+// discardHandler is a slog.Handler that drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// discardLogger is the logger used when no logger is set
+var discardLogger = slog.New(discardHandler{})
+
+// GetLogger returns the logger for lock operations
+func (l *SafeLock) GetLogger() *slog.Logger {
+	return l.logger
+}
+
+// SetLogger sets the logger for lock operations
+// Acquisitions, releases and takeovers are logged at the info level, force unlocks, retries and
+// waits that time out at the warn level, failures at the error level and wait loop iterations at the
+// debug level. A nil logger disables logging, which is the default.
+func (l *SafeLock) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger
+	}
+	l.logger = logger
+}
+
+// WithLogger sets the logger for lock operations
+func WithLogger(logger *slog.Logger) Option {
+	return func(l configurable) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		l.SetLogger(logger)
+		return nil
+	}
+}
+
+// lockLogger returns the logger with the attributes that identify the lock and this session
+func (l *SafeLock) lockLogger(uri string) *slog.Logger {
+	attrs := []any{
+		slog.String("uri", uri),
+		slog.Int("node", int(l.node)),
+		slog.String("id", l.id.String()),
+	}
+	if len(l.nodeName) > 0 {
+		attrs = append(attrs, slog.String("node_name", l.nodeName))
+	}
+	return l.GetLogger().With(attrs...)
+}
+
+// holderAttr returns the attribute that describes the holder of a lock
+func holderAttr(body *lockBody) slog.Attr {
+	if body == nil {
+		return slog.Group("holder")
+	}
+	attrs := []any{
+		slog.String("id", body.id.String()),
+		slog.Time("acquired", body.timestamp),
+	}
+	if len(body.node) == 2 {
+		attrs = append(attrs, slog.Int("node", int(binary.LittleEndian.Uint16(body.node))))
+	}
+	if len(body.nodeName) > 0 {
+		attrs = append(attrs, slog.String("node_name", body.nodeName))
+	}
+	if len(body.hostname) > 0 {
+		attrs = append(attrs, slog.String("hostname", body.hostname))
+	}
+	if !body.expiresAt.IsZero() {
+		attrs = append(attrs, slog.Time("expires_at", body.expiresAt))
+	}
+	if len(body.versionID) > 0 {
+		attrs = append(attrs, slog.String("version_id", body.versionID))
+	}
+	return slog.Group("holder", attrs...)
+}
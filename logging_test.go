@@ -0,0 +1,74 @@
+package safelock
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+
+	l := NewSafeLock(0)
+	assert.Equal(t, discardLogger, l.GetLogger())
+
+	logger := slog.New(slog.NewTextHandler(new(bytes.Buffer), nil))
+	assert.NoError(t, WithLogger(logger)(l))
+	assert.Equal(t, logger, l.GetLogger())
+	assert.Error(t, WithLogger(nil)(l))
+
+	// Disable logging
+	l.SetLogger(nil)
+	assert.Equal(t, discardLogger, l.GetLogger())
+}
+
+func TestFileLockLogging(t *testing.T) {
+
+	out := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	fs := afero.NewMemMapFs()
+	l, errNew := NewFileLockWithOptions(1, "file.txt", fs, WithLogger(logger), WithNodeName("worker-1"))
+	require.NoError(t, errNew)
+
+	assert.NoError(t, l.WaitForLock(time.Second))
+	assert.Contains(t, out.String(), `msg="lock is available"`)
+
+	assert.NoError(t, l.Lock())
+	assert.Contains(t, out.String(), `msg="lock acquired"`)
+	assert.Contains(t, out.String(), "uri="+l.GetLockURI())
+	assert.Contains(t, out.String(), "node=1")
+	assert.Contains(t, out.String(), "id="+l.GetSessionID().String())
+	assert.Contains(t, out.String(), "node_name=worker-1")
+
+	// Another session of a different node is refused
+	other, errNew := NewFileLockWithOptions(2, "file.txt", fs, WithLogger(logger))
+	require.NoError(t, errNew)
+	out.Reset()
+	assert.ErrorIs(t, other.Lock(), ErrLocked)
+	assert.Contains(t, out.String(), `msg="lock is held by another session"`)
+
+	// A new session of the same node takes over
+	next, errNew := NewFileLockWithOptions(1, "file.txt", fs, WithLogger(logger), WithOwnershipMode(OwnershipModeNode))
+	require.NoError(t, errNew)
+	out.Reset()
+	assert.NoError(t, next.Lock())
+	assert.Contains(t, out.String(), `msg="taking over lock" `)
+	assert.Contains(t, out.String(), `reason=prior_session`)
+	assert.Contains(t, out.String(), "holder.id="+l.GetSessionID().String())
+
+	out.Reset()
+	assert.NoError(t, next.Unlock())
+	assert.Contains(t, out.String(), `msg="lock released"`)
+	assert.Contains(t, out.String(), "holder.id="+next.GetSessionID().String())
+
+	assert.NoError(t, other.Lock())
+	out.Reset()
+	assert.NoError(t, next.ForceUnlock())
+	assert.Contains(t, out.String(), `level=WARN msg="lock force unlocked"`)
+	assert.Contains(t, out.String(), "holder.id="+other.GetSessionID().String())
+}
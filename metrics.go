@@ -0,0 +1,283 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultMetricsNamespace is the default prefix of the names of lock metrics
+	DefaultMetricsNamespace = "safelock"
+
+	// takeoverExpired is the reason for taking over a lock that has expired
+	takeoverExpired = "expired"
+
+	// takeoverPriorSession is the reason for taking over a lock held by a prior session of this node
+	takeoverPriorSession = "prior_session"
+)
+
+// LockPattern maps a lock URI to the value of the lock label of lock metrics
+// Patterns should group locks so that the number of label values stays small.
+type LockPattern func(uri string) string
+
+// LockPatternDir replaces the last element of the lock URI's path with *, grouping the
+// locks in the same directory or under the same S3 prefix
+// For example s3://bucket/data/file.csv.lock becomes s3://bucket/data/*.
+func LockPatternDir(uri string) string {
+	u, errParse := url.Parse(uri)
+	if errParse != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + strings.TrimSuffix(path.Dir(u.Path), "/") + "/*"
+}
+
+// MetricsOptions are the options used by NewMetrics
+type MetricsOptions struct {
+	// Namespace is the prefix of the metric names, DefaultMetricsNamespace is used if empty
+	Namespace string
+	// LockPattern maps lock URIs to the lock label, LockPatternDir is used if nil
+	LockPattern LockPattern
+	// WaitBuckets are the buckets of the wait time histogram in seconds, prometheus.DefBuckets are used if nil
+	WaitBuckets []float64
+	// HoldBuckets are the buckets of the hold time histogram in seconds, exponential buckets from 100ms to 7h are used if nil
+	HoldBuckets []float64
+}
+
+// Metrics is a Prometheus collector of lock metrics
+// Every metric is labeled by backend, the scheme of the lock URI, and lock, the pattern of the lock URI.
+// A Metrics can be shared by many locks and must be registered with a Prometheus registry.
+type Metrics struct {
+	lockPattern LockPattern
+
+	acquisitions *prometheus.CounterVec
+	failures     *prometheus.CounterVec
+	takeovers    *prometheus.CounterVec
+	forceUnlocks *prometheus.CounterVec
+	waitTime     *prometheus.HistogramVec
+	holdTime     *prometheus.HistogramVec
+	held         *prometheus.GaugeVec
+}
+
+var _ prometheus.Collector = (*Metrics)(nil)
+
+// NewMetrics creates a new instance of Metrics
+func NewMetrics(opts MetricsOptions) *Metrics {
+	namespace := opts.Namespace
+	if len(namespace) == 0 {
+		namespace = DefaultMetricsNamespace
+	}
+	lockPattern := opts.LockPattern
+	if lockPattern == nil {
+		lockPattern = LockPatternDir
+	}
+	waitBuckets := opts.WaitBuckets
+	if waitBuckets == nil {
+		waitBuckets = prometheus.DefBuckets
+	}
+	holdBuckets := opts.HoldBuckets
+	if holdBuckets == nil {
+		holdBuckets = prometheus.ExponentialBuckets(0.1, 4, 10)
+	}
+
+	labels := []string{"backend", "lock"}
+	return &Metrics{
+		lockPattern: lockPattern,
+		acquisitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "acquisitions_total",
+			Help:      "The number of locks acquired.",
+		}, labels),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failures_total",
+			Help:      "The number of lock operations that failed, by operation and reason.",
+		}, append(labels, "operation", "reason")),
+		takeovers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "takeovers_total",
+			Help:      "The number of locks taken over because they expired or were held by a prior session of the same node.",
+		}, append(labels, "reason")),
+		forceUnlocks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "force_unlocks_total",
+			Help:      "The number of locks released with ForceUnlock.",
+		}, labels),
+		waitTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "wait_seconds",
+			Help:      "The time spent in WaitForLock.",
+			Buckets:   waitBuckets,
+		}, labels),
+		holdTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "hold_seconds",
+			Help:      "The time locks were held when they were released, according to the holder's clock.",
+			Buckets:   holdBuckets,
+		}, labels),
+		held: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "held_locks",
+			Help:      "The number of locks currently held.",
+		}, labels),
+	}
+}
+
+// Describe sends the descriptors of the lock metrics
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect sends the lock metrics
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// collectors returns the collectors of each metric
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.acquisitions, m.failures, m.takeovers, m.forceUnlocks, m.waitTime, m.holdTime, m.held}
+}
+
+// labels returns the backend and lock labels for a lock URI
+func (m *Metrics) labels(uri string) prometheus.Labels {
+	backend := ""
+	if u, errParse := url.Parse(uri); errParse == nil {
+		backend = u.Scheme
+	}
+	return prometheus.Labels{"backend": backend, "lock": m.lockPattern(uri)}
+}
+
+// observeAcquire records the outcome of Lock
+func (m *Metrics) observeAcquire(uri string, errLock error) {
+	if m == nil {
+		return
+	}
+	labels := m.labels(uri)
+	if errLock != nil {
+		m.observeFailure(labels, "lock", errLock)
+		return
+	}
+	m.acquisitions.With(labels).Inc()
+}
+
+// observeHolding records a lock that became held by this session, or stopped being held because it
+// was released or lost
+func (m *Metrics) observeHolding(uri string, holding bool) {
+	if m == nil {
+		return
+	}
+	if holding {
+		m.held.With(m.labels(uri)).Inc()
+	} else {
+		m.held.With(m.labels(uri)).Dec()
+	}
+}
+
+// observeTakeover records the takeover of a lock
+func (m *Metrics) observeTakeover(uri string, reason string) {
+	if m == nil {
+		return
+	}
+	labels := m.labels(uri)
+	labels["reason"] = reason
+	m.takeovers.With(labels).Inc()
+}
+
+// observeRelease records the release of a lock by Unlock or ForceUnlock
+func (m *Metrics) observeRelease(uri string, force bool, heldFor time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := m.labels(uri)
+	if force {
+		m.forceUnlocks.With(labels).Inc()
+	}
+	if heldFor > 0 {
+		m.holdTime.With(labels).Observe(heldFor.Seconds())
+	}
+}
+
+// observeReleaseFailure records the failure of Unlock or ForceUnlock
+func (m *Metrics) observeReleaseFailure(uri string, force bool, errUnlock error) {
+	if m == nil {
+		return
+	}
+	operation := "unlock"
+	if force {
+		operation = "force_unlock"
+	}
+	m.observeFailure(m.labels(uri), operation, errUnlock)
+}
+
+// observeWait records the outcome of WaitForLock
+func (m *Metrics) observeWait(uri string, duration time.Duration, errWait error) {
+	if m == nil {
+		return
+	}
+	labels := m.labels(uri)
+	m.waitTime.With(labels).Observe(duration.Seconds())
+	if errWait != nil {
+		m.observeFailure(labels, "wait", errWait)
+	}
+}
+
+// observeFailure records a failed operation
+func (m *Metrics) observeFailure(labels prometheus.Labels, operation string, err error) {
+	failureLabels := prometheus.Labels{"operation": operation, "reason": failureReason(err)}
+	for k, v := range labels {
+		failureLabels[k] = v
+	}
+	m.failures.With(failureLabels).Inc()
+}
+
+// failureReason classifies the error of a failed operation for the reason label
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrLocked):
+		return "locked"
+	case errors.Is(err, ErrNotLocked):
+		return "not_locked"
+	case errors.Is(err, ErrWrongNode):
+		return "wrong_node"
+	case errors.Is(err, ErrWrongSession):
+		return "wrong_session"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrMaxAttemptsExceeded):
+		return "max_attempts"
+	default:
+		return "error"
+	}
+}
+
+// GetMetrics returns the collector of lock metrics, nil if metrics are not collected
+func (l *SafeLock) GetMetrics() *Metrics {
+	return l.metrics
+}
+
+// SetMetrics sets the collector of lock metrics, a nil collector disables metrics which is the default
+func (l *SafeLock) SetMetrics(metrics *Metrics) {
+	l.metrics = metrics
+}
+
+// WithMetrics sets the collector of lock metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(l configurable) error {
+		if metrics == nil {
+			return errors.New("metrics must not be nil")
+		}
+		l.SetMetrics(metrics)
+		return nil
+	}
+}
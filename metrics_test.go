@@ -0,0 +1,114 @@
+package safelock
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockPatternDir(t *testing.T) {
+
+	assert.Equal(t, "s3://bucket/data/*", LockPatternDir("s3://bucket/data/file.csv.lock"))
+	assert.Equal(t, "s3://bucket/*", LockPatternDir("s3://bucket/file.csv.lock"))
+	assert.Equal(t, "file:///tmp/*", LockPatternDir("file:///tmp/file.txt.lock"))
+}
+
+func TestFailureReason(t *testing.T) {
+
+	assert.Equal(t, "locked", failureReason(fmt.Errorf("the object is locked: %w", ErrLocked)))
+	assert.Equal(t, "not_locked", failureReason(fmt.Errorf("the object is not locked: %w", ErrNotLocked)))
+	assert.Equal(t, "wrong_node", failureReason(ErrWrongNode))
+	assert.Equal(t, "wrong_session", failureReason(ErrWrongSession))
+	assert.Equal(t, "timeout", failureReason(fmt.Errorf("unable to obtain lock: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "max_attempts", failureReason(ErrMaxAttemptsExceeded))
+	assert.Equal(t, "error", failureReason(assert.AnError))
+}
+
+func TestMetrics(t *testing.T) {
+
+	m := NewMetrics(MetricsOptions{})
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(m))
+
+	l := NewSafeLock(0)
+	assert.Nil(t, l.GetMetrics())
+	assert.NoError(t, WithMetrics(m)(l))
+	assert.Equal(t, m, l.GetMetrics())
+	assert.Error(t, WithMetrics(nil)(l))
+
+	// Disable metrics
+	l.SetMetrics(nil)
+	assert.Nil(t, l.GetMetrics())
+}
+
+func TestFileLockMetrics(t *testing.T) {
+
+	m := NewMetrics(MetricsOptions{Namespace: "test"})
+	fs := afero.NewMemMapFs()
+	l, errNew := NewFileLockWithOptions(1, "/data/file.txt", fs, WithMetrics(m))
+	require.NoError(t, errNew)
+	labels := prometheus.Labels{"backend": "file", "lock": "file:///data/*"}
+
+	assert.NoError(t, l.WaitForLock(time.Second))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.waitTime))
+
+	assert.NoError(t, l.Lock())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.acquisitions.With(labels)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.held.With(labels)))
+
+	// Another node is refused
+	other, errNew := NewFileLockWithOptions(2, "/data/file.txt", fs, WithMetrics(m))
+	require.NoError(t, errNew)
+	assert.ErrorIs(t, other.Lock(), ErrLocked)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.failures.With(prometheus.Labels{
+		"backend": "file", "lock": "file:///data/*", "operation": "lock", "reason": "locked",
+	})))
+	assert.ErrorIs(t, other.Unlock(), ErrWrongNode)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.failures.With(prometheus.Labels{
+		"backend": "file", "lock": "file:///data/*", "operation": "unlock", "reason": "wrong_node",
+	})))
+
+	// A new session of the same node takes over
+	next, errNew := NewFileLockWithOptions(1, "/data/file.txt", fs, WithMetrics(m), WithOwnershipMode(OwnershipModeNode))
+	require.NoError(t, errNew)
+	assert.NoError(t, next.Lock())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.takeovers.With(prometheus.Labels{
+		"backend": "file", "lock": "file:///data/*", "reason": "prior_session",
+	})))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.acquisitions.With(labels)))
+
+	assert.NoError(t, next.Unlock())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.held.With(labels)))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.holdTime))
+
+	// Force unlocking another session's lock does not change the locks held by this session
+	assert.NoError(t, other.Lock())
+	assert.NoError(t, next.ForceUnlock())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.forceUnlocks.With(labels)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.held.With(labels)))
+
+	// The first session finds that its lock was lost
+	held, errIsHeld := l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.False(t, held)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.held.With(labels)))
+	assert.ErrorIs(t, l.Unlock(), ErrNotLocked)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.held.With(labels)))
+
+	// Locking again is not counted twice by a session that has not released the lock
+	assert.NoError(t, other.Lock())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.held.With(labels)))
+	assert.NoError(t, other.Unlock())
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.held.With(labels)))
+
+	problems, errLint := testutil.CollectAndLint(m)
+	assert.NoError(t, errLint)
+	assert.Empty(t, problems)
+}
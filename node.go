@@ -0,0 +1,91 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// machineIDPaths are the locations of the machine id, in order of preference
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// NodeNumberFromName derives a node number from a node name for readers that only compare node numbers
+// Different names may derive the same number, so the name should also be set with SetNodeName.
+func NodeNumberFromName(nodeName string) uint16 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(nodeName))
+	sum := h.Sum32()
+	return uint16(sum>>16) ^ uint16(sum)
+}
+
+// NodeFromHostname returns a node name from the hostname reported by the kernel
+func NodeFromHostname() (string, error) {
+	hostname, errHostname := os.Hostname()
+	if errHostname != nil {
+		return "", fmt.Errorf("unable to get hostname: %w", errHostname)
+	}
+	return hostname, nil
+}
+
+// NodeFromMachineID returns a node name from the systemd or D-Bus machine id
+func NodeFromMachineID() (string, error) {
+	for _, path := range machineIDPaths {
+		// #nosec G304 the paths are fixed
+		data, errRead := ioutil.ReadFile(path)
+		if errRead != nil {
+			if errors.Is(errRead, os.ErrNotExist) {
+				continue
+			}
+			return "", fmt.Errorf("unable to read %q: %w", path, errRead)
+		}
+		machineID := strings.TrimSpace(string(data))
+		if len(machineID) > 0 {
+			return machineID, nil
+		}
+	}
+	return "", errors.New("unable to find a machine id")
+}
+
+// NodeFromEC2InstanceID returns a node name from the EC2 instance id reported by the instance metadata service
+func NodeFromEC2InstanceID(ctx context.Context, svcIMDS LockIMDSClient) (string, error) {
+	getMetadataOutput, errGetMetadata := svcIMDS.GetMetadata(ctx, &imds.GetMetadataInput{
+		Path: "instance-id",
+	})
+	if errGetMetadata != nil {
+		return "", fmt.Errorf("unable to get instance id: %w", errGetMetadata)
+	}
+	defer getMetadataOutput.Content.Close()
+
+	data, errRead := ioutil.ReadAll(getMetadataOutput.Content)
+	if errRead != nil {
+		return "", fmt.Errorf("unable to read instance id: %w", errRead)
+	}
+	instanceID := strings.TrimSpace(string(data))
+	if len(instanceID) == 0 {
+		return "", errors.New("instance id is empty")
+	}
+	return instanceID, nil
+}
+
+// NodeFromKubernetesPod returns a node name of the form namespace/name for the current Kubernetes pod
+// The pod name is read from the POD_NAME environment variable, which is usually set with the downward API,
+// and otherwise from HOSTNAME when running in Kubernetes. The namespace is read from POD_NAMESPACE if set.
+func NodeFromKubernetesPod() (string, error) {
+	podName := os.Getenv("POD_NAME")
+	if len(podName) == 0 && len(os.Getenv("KUBERNETES_SERVICE_HOST")) > 0 {
+		podName = os.Getenv("HOSTNAME")
+	}
+	if len(podName) == 0 {
+		return "", errors.New("unable to determine the Kubernetes pod name")
+	}
+	if podNamespace := os.Getenv("POD_NAMESPACE"); len(podNamespace) > 0 {
+		return podNamespace + "/" + podName, nil
+	}
+	return podName, nil
+}
@@ -0,0 +1,65 @@
+package safelock
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeNumberFromName(t *testing.T) {
+	assert.Equal(t, NodeNumberFromName("host-a"), NodeNumberFromName("host-a"))
+	assert.NotEqual(t, NodeNumberFromName("host-a"), NodeNumberFromName("host-b"))
+}
+
+func TestNodeFromHostname(t *testing.T) {
+	hostname, errHostname := os.Hostname()
+	assert.NoError(t, errHostname)
+
+	nodeName, errNode := NodeFromHostname()
+	assert.NoError(t, errNode)
+	assert.Equal(t, hostname, nodeName)
+}
+
+func TestNodeFromEC2InstanceID(t *testing.T) {
+	svcIMDS := mocks.MockIMDSClient{
+		Metadata: map[string]string{
+			"instance-id": "i-0123456789abcdef0\n",
+		},
+	}
+
+	nodeName, errNode := NodeFromEC2InstanceID(context.Background(), &svcIMDS)
+	assert.NoError(t, errNode)
+	assert.Equal(t, "i-0123456789abcdef0", nodeName)
+
+	// Not running in EC2
+	svcIMDS.Metadata = nil
+	_, errNode = NodeFromEC2InstanceID(context.Background(), &svcIMDS)
+	assert.Error(t, errNode)
+}
+
+func TestNodeFromKubernetesPod(t *testing.T) {
+	t.Setenv("POD_NAME", "")
+	t.Setenv("POD_NAMESPACE", "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	_, errNode := NodeFromKubernetesPod()
+	assert.Error(t, errNode)
+
+	// The hostname is the pod name when running in Kubernetes
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("HOSTNAME", "worker-7d9f8")
+	nodeName, errNode := NodeFromKubernetesPod()
+	assert.NoError(t, errNode)
+	assert.Equal(t, "worker-7d9f8", nodeName)
+
+	// The downward API takes precedence
+	t.Setenv("POD_NAME", "worker-0")
+	t.Setenv("POD_NAMESPACE", "jobs")
+	nodeName, errNode = NodeFromKubernetesPod()
+	assert.NoError(t, errNode)
+	assert.Equal(t, "jobs/worker-0", nodeName)
+}
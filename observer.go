@@ -0,0 +1,163 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LockEvent describes a change of the state of a lock reported to a LockObserver
+type LockEvent struct {
+	// URI is the URI of the lock object
+	URI string
+	// Node is the node number of the lock that observed the event
+	Node uint16
+	// SessionID is the session id of the lock that observed the event
+	SessionID uuid.UUID
+	// Time is when the event was observed, according to the lock's clock
+	Time time.Time
+	// Holder describes the holder involved in the event, it is nil for an acquired lock or if it is unknown
+	// For a contended lock it is the current holder, for a takeover, release or force unlock it is the
+	// holder of the lock that was removed and for a lost lock it is the current holder, if any.
+	Holder *LockOwner
+	// Err is the error that revealed a lost lock, it is nil for other events
+	Err error
+}
+
+// LockObserver is notified of the changes of the state of locks
+// Observers are called synchronously, in the order they were added, by the goroutine that observed
+// the change, so they should return quickly and must not block on the lock.
+type LockObserver interface {
+	// OnAcquired is called when Lock acquires the lock
+	OnAcquired(ctx context.Context, event LockEvent)
+	// OnReleased is called when Unlock releases the lock
+	OnReleased(ctx context.Context, event LockEvent)
+	// OnContended is called when Lock fails because the lock is held by another session
+	OnContended(ctx context.Context, event LockEvent)
+	// OnExpiredTakeover is called when Lock takes over a lock that has expired
+	OnExpiredTakeover(ctx context.Context, event LockEvent)
+	// OnLost is called when a lock acquired by this session is found to be no longer held by it
+	// This is checked by Lock, Unlock and IsHeld, the lock is not watched in the background.
+	OnLost(ctx context.Context, event LockEvent)
+	// OnForceUnlocked is called when ForceUnlock removes the lock
+	OnForceUnlocked(ctx context.Context, event LockEvent)
+}
+
+// LockObserverFuncs is a LockObserver that calls its functions, functions that are nil are skipped
+type LockObserverFuncs struct {
+	Acquired        func(ctx context.Context, event LockEvent)
+	Released        func(ctx context.Context, event LockEvent)
+	Contended       func(ctx context.Context, event LockEvent)
+	ExpiredTakeover func(ctx context.Context, event LockEvent)
+	Lost            func(ctx context.Context, event LockEvent)
+	ForceUnlocked   func(ctx context.Context, event LockEvent)
+}
+
+var _ LockObserver = LockObserverFuncs{}
+
+// OnAcquired calls Acquired if it is set
+func (o LockObserverFuncs) OnAcquired(ctx context.Context, event LockEvent) {
+	if o.Acquired != nil {
+		o.Acquired(ctx, event)
+	}
+}
+
+// OnReleased calls Released if it is set
+func (o LockObserverFuncs) OnReleased(ctx context.Context, event LockEvent) {
+	if o.Released != nil {
+		o.Released(ctx, event)
+	}
+}
+
+// OnContended calls Contended if it is set
+func (o LockObserverFuncs) OnContended(ctx context.Context, event LockEvent) {
+	if o.Contended != nil {
+		o.Contended(ctx, event)
+	}
+}
+
+// OnExpiredTakeover calls ExpiredTakeover if it is set
+func (o LockObserverFuncs) OnExpiredTakeover(ctx context.Context, event LockEvent) {
+	if o.ExpiredTakeover != nil {
+		o.ExpiredTakeover(ctx, event)
+	}
+}
+
+// OnLost calls Lost if it is set
+func (o LockObserverFuncs) OnLost(ctx context.Context, event LockEvent) {
+	if o.Lost != nil {
+		o.Lost(ctx, event)
+	}
+}
+
+// OnForceUnlocked calls ForceUnlocked if it is set
+func (o LockObserverFuncs) OnForceUnlocked(ctx context.Context, event LockEvent) {
+	if o.ForceUnlocked != nil {
+		o.ForceUnlocked(ctx, event)
+	}
+}
+
+// GetObservers returns the observers of the lock
+func (l *SafeLock) GetObservers() []LockObserver {
+	l.observersMu.RLock()
+	defer l.observersMu.RUnlock()
+	return append([]LockObserver(nil), l.observers...)
+}
+
+// AddObserver adds an observer of the lock, which is notified after the observers already added
+func (l *SafeLock) AddObserver(observer LockObserver) {
+	l.observersMu.Lock()
+	defer l.observersMu.Unlock()
+	l.observers = append(l.observers, observer)
+}
+
+// WithObserver adds an observer of the lock
+func WithObserver(observer LockObserver) Option {
+	return func(l configurable) error {
+		if observer == nil {
+			return errors.New("observer must not be nil")
+		}
+		l.AddObserver(observer)
+		return nil
+	}
+}
+
+// notify calls the observers of the lock with an event for the lock at the URI
+func (l *SafeLock) notify(ctx context.Context, uri string, holder *LockOwner, err error, call func(LockObserver, context.Context, LockEvent)) {
+	observers := l.GetObservers()
+	if len(observers) == 0 {
+		return
+	}
+	event := LockEvent{
+		URI:       uri,
+		Node:      l.node,
+		SessionID: l.id,
+		Time:      l.GetClock().Now(),
+		Holder:    holder,
+		Err:       err,
+	}
+	for _, observer := range observers {
+		call(observer, ctx, event)
+	}
+}
+
+// lost notifies the observers that the lock at the URI is no longer held by this session, if it
+// was acquired by this session
+func (l *SafeLock) lost(ctx context.Context, uri string, holder *LockOwner, err error) {
+	if !l.holding.Swap(false) {
+		return
+	}
+	l.GetMetrics().observeHolding(uri, false)
+	l.notify(ctx, uri, holder, err, LockObserver.OnLost)
+}
+
+// bodyOwner describes the holder of a lock body for an event, it returns nil if the body is nil
+// The expiration is judged from the time the holder wrote the lock.
+func (l *SafeLock) bodyOwner(body *lockBody) *LockOwner {
+	if body == nil {
+		return nil
+	}
+	return l.newLockOwner(body, body.timestamp)
+}
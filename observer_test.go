@@ -0,0 +1,163 @@
+package safelock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingObserver records the names of the events it is notified of
+type recordingObserver struct {
+	events []string
+	last   LockEvent
+}
+
+func (o *recordingObserver) record(name string) func(context.Context, LockEvent) {
+	return func(ctx context.Context, event LockEvent) {
+		o.events = append(o.events, name)
+		o.last = event
+	}
+}
+
+func (o *recordingObserver) observer() LockObserver {
+	return LockObserverFuncs{
+		Acquired:        o.record("acquired"),
+		Released:        o.record("released"),
+		Contended:       o.record("contended"),
+		ExpiredTakeover: o.record("expired_takeover"),
+		Lost:            o.record("lost"),
+		ForceUnlocked:   o.record("force_unlocked"),
+	}
+}
+
+func TestObservers(t *testing.T) {
+
+	l := NewSafeLock(0)
+	assert.Empty(t, l.GetObservers())
+
+	first, second := &recordingObserver{}, &recordingObserver{}
+	assert.NoError(t, WithObserver(first.observer())(l))
+	assert.NoError(t, WithObserver(second.observer())(l))
+	assert.Len(t, l.GetObservers(), 2)
+	assert.Error(t, WithObserver(nil)(l))
+
+	// Every observer is notified
+	l.notify(context.Background(), "file:///file.txt", nil, nil, LockObserver.OnAcquired)
+	assert.Equal(t, []string{"acquired"}, first.events)
+	assert.Equal(t, []string{"acquired"}, second.events)
+	assert.Equal(t, "file:///file.txt", second.last.URI)
+	assert.Equal(t, l.GetSessionID(), second.last.SessionID)
+
+	// A lock that was not acquired can not be lost
+	l.lost(context.Background(), "file:///file.txt", nil, nil)
+	assert.Equal(t, []string{"acquired"}, first.events)
+
+	// The zero value ignores every event
+	LockObserverFuncs{}.OnLost(context.Background(), LockEvent{})
+}
+
+func TestFileLockObserver(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	obs := &recordingObserver{}
+	l, errNew := NewFileLockWithOptions(1, "file.txt", fs, WithObserver(obs.observer()))
+	require.NoError(t, errNew)
+	l.SetClock(c)
+
+	assert.NoError(t, l.Lock())
+
+	// Locking again does not lose the lock held by this session
+	assert.ErrorIs(t, l.Lock(), ErrLocked)
+	assert.Equal(t, []string{"acquired", "contended"}, obs.events)
+	held, errIsHeld := l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.True(t, held)
+
+	obs.events = nil
+	assert.NoError(t, l.Unlock())
+	assert.Equal(t, []string{"released"}, obs.events)
+	assert.Equal(t, l.GetSessionID(), obs.last.Holder.SessionID)
+
+	// Another session of a different node holds the lock
+	other := NewFileLock(2, "file.txt", fs)
+	other.SetClock(c)
+	assert.NoError(t, other.Lock())
+	obs.events = nil
+	assert.ErrorIs(t, l.Lock(), ErrLocked)
+	assert.Equal(t, []string{"contended"}, obs.events)
+	assert.Equal(t, other.GetSessionID(), obs.last.Holder.SessionID)
+
+	// The lock of the other session expires and is taken over
+	expired := c.Now()
+	c.Advance(DefaultTimeout + DefaultClockSkew + time.Second)
+	assert.NoError(t, fs.Chtimes(other.GetLockFilename(), expired, expired))
+	obs.events = nil
+	assert.NoError(t, l.Lock())
+	assert.Equal(t, []string{"expired_takeover", "acquired"}, obs.events)
+
+	// The lock is lost when it is force unlocked and taken by the other session
+	assert.NoError(t, other.ForceUnlock())
+	assert.NoError(t, other.Lock())
+	obs.events = nil
+	held, errIsHeld = l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.False(t, held)
+	assert.Equal(t, []string{"lost"}, obs.events)
+	assert.Equal(t, other.GetSessionID(), obs.last.Holder.SessionID)
+
+	// A lost lock is only reported once
+	assert.ErrorIs(t, l.Unlock(), ErrWrongNode)
+	assert.Equal(t, []string{"lost"}, obs.events)
+
+	assert.NoError(t, l.ForceUnlock())
+	assert.Equal(t, []string{"lost", "force_unlocked"}, obs.events)
+	assert.Equal(t, other.GetSessionID(), obs.last.Holder.SessionID)
+}
+
+func TestS3ObjectLockObserverTakeoverFailure(t *testing.T) {
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	// The lock of another node has expired
+	other := NewS3ObjectLock(1, "bucket", "key", "", &mocks.MockS3Client{})
+	other.SetClock(c)
+	otherBody, errParse := parseLockBody(other.GetLockBody())
+	require.NoError(t, errParse)
+	svcS3 := mocks.MockS3Client{
+		HeadObjectOutput: &s3.HeadObjectOutput{
+			Metadata:     lockMetadata(otherBody, ""),
+			LastModified: aws.Time(c.Now()),
+		},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+	}
+	c.Advance(time.Hour)
+
+	obs := &recordingObserver{}
+	m := NewMetrics(MetricsOptions{})
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3,
+		WithClock(c),
+		WithObserver(obs.observer()),
+		WithMetrics(m),
+	)
+	require.NoError(t, errNew)
+
+	// The takeover is not reported if the lock can't be written
+	assert.Error(t, l.Lock())
+	assert.NotNil(t, svcS3.DeleteObjectInput)
+	assert.Empty(t, obs.events)
+	assert.Equal(t, 0, testutil.CollectAndCount(m.takeovers))
+
+	svcS3.PutObjectOutput = &s3.PutObjectOutput{}
+	assert.NoError(t, l.Lock())
+	assert.Equal(t, []string{"expired_takeover", "acquired"}, obs.events)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.takeovers))
+}
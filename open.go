@@ -0,0 +1,229 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/afero"
+)
+
+// OpenOptions are the options used by Open to construct a lock
+type OpenOptions struct {
+	// Node is the node number of the lock
+	Node uint16
+	// Fs is the filesystem used for file URIs, the operating system filesystem is used if nil
+	Fs afero.Fs
+	// S3Client is the client used for s3 URIs, a client is created from the default AWS configuration if nil
+	S3Client LockS3Client
+	// Options are applied to the lock after the options given by the URI's query parameters
+	Options []Option
+}
+
+// Opener constructs a lock for a URI with a registered scheme
+// The query holds the URI's query parameters that are not common to all locks,
+// and the opener should return an error for any it does not recognize.
+// The options for the common query parameters are prepended to opts.Options, and a lock
+// that embeds *SafeLock is configured by calling each option with the lock.
+type Opener func(ctx context.Context, uri *url.URL, query url.Values, opts OpenOptions) (SafeLockiface, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = map[string]Opener{
+		"file": openFileLock,
+		"s3":   openS3ObjectLock,
+	}
+)
+
+// RegisterScheme registers the opener used by Open for URIs with the scheme, replacing any existing opener
+func RegisterScheme(scheme string, opener Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	openers[strings.ToLower(scheme)] = opener
+}
+
+// Open returns a lock for the object at the URI, for example s3://bucket/key or file:///path/to/file
+// The query parameters suffix and timeout are supported for every scheme, for example
+// s3://bucket/key?suffix=.lock&timeout=1m. The s3 scheme also supports sse, kms, lockbucket,
+// lockprefix, acl, acquire, hold, versioned, region, endpoint and pathstyle, and the file scheme
+// supports lockdir. The versioned parameter may be auto to detect the bucket's versioning.
+func Open(ctx context.Context, uri string, opts OpenOptions) (SafeLockiface, error) {
+	u, errParse := url.Parse(uri)
+	if errParse != nil {
+		return nil, fmt.Errorf("unable to parse %q: %w", uri, errParse)
+	}
+
+	openersMu.RLock()
+	opener, ok := openers[strings.ToLower(u.Scheme)]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported lock URI scheme %q", u.Scheme)
+	}
+
+	// Remove the parameters common to all locks before calling the opener
+	query := u.Query()
+	queryOptions := []Option{}
+	if query.Has("suffix") {
+		queryOptions = append(queryOptions, WithSuffix(query.Get("suffix")))
+	}
+	if timeout := query.Get("timeout"); len(timeout) > 0 {
+		d, errParseDuration := time.ParseDuration(timeout)
+		if errParseDuration != nil {
+			return nil, fmt.Errorf("timeout %q is not valid: %w", timeout, errParseDuration)
+		}
+		queryOptions = append(queryOptions, WithTimeout(d))
+	}
+	query.Del("suffix")
+	query.Del("timeout")
+
+	opts.Options = append(queryOptions, opts.Options...)
+
+	return opener(ctx, u, query, opts)
+}
+
+// openFileLock opens a FileLock for a file URI
+func openFileLock(ctx context.Context, uri *url.URL, query url.Values, opts OpenOptions) (SafeLockiface, error) {
+	options := []Option{}
+	if lockDir := query.Get("lockdir"); len(lockDir) > 0 {
+		options = append(options, WithLockDir(lockDir))
+	}
+	query.Del("lockdir")
+	if errQuery := checkQuery(query); errQuery != nil {
+		return nil, errQuery
+	}
+	if len(uri.Host) > 0 && uri.Host != "localhost" {
+		return nil, fmt.Errorf("file URI %q must not have a host", uri)
+	}
+	if len(uri.Path) == 0 {
+		return nil, fmt.Errorf("file URI %q must have a path", uri)
+	}
+
+	fs := opts.Fs
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+
+	l, errNew := NewFileLockWithOptions(opts.Node, uri.Path, fs, append(options, opts.Options...)...)
+	if errNew != nil {
+		return nil, errNew
+	}
+	return l, nil
+}
+
+// openS3ObjectLock opens an S3ObjectLock for an s3 URI
+func openS3ObjectLock(ctx context.Context, uri *url.URL, query url.Values, opts OpenOptions) (SafeLockiface, error) {
+	options := []Option{}
+	if sse := query.Get("sse"); len(sse) > 0 {
+		options = append(options, WithS3Encryption(S3Encryption{
+			Mode:     S3EncryptionMode(sse),
+			KMSKeyID: query.Get("kms"),
+		}))
+	} else if kmsKeyArn := query.Get("kms"); len(kmsKeyArn) > 0 {
+		options = append(options, WithKMS(kmsKeyArn))
+	}
+	lockBucket, lockPrefix := query.Get("lockbucket"), query.Get("lockprefix")
+	if len(lockPrefix) > 0 {
+		options = append(options, WithS3LockLocator(S3LockInPrefix(lockBucket, lockPrefix)))
+	} else if len(lockBucket) > 0 {
+		options = append(options, WithS3LockLocator(S3LockInBucket(lockBucket)))
+	}
+	if acl := query.Get("acl"); len(acl) > 0 {
+		if acl == "none" {
+			acl = ""
+		}
+		options = append(options, WithS3ACL(types.ObjectCannedACL(acl)))
+	}
+	if acquire := query.Get("acquire"); len(acquire) > 0 {
+		options = append(options, WithS3AcquireMode(S3AcquireMode(acquire)))
+	}
+	if hold := query.Get("hold"); len(hold) > 0 {
+		options = append(options, WithS3HoldMode(S3HoldMode(hold)))
+	}
+	versioned := query.Get("versioned")
+	if len(versioned) > 0 && versioned != "auto" {
+		b, errParseBool := strconv.ParseBool(versioned)
+		if errParseBool != nil {
+			return nil, fmt.Errorf("versioned %q is not valid: %w", versioned, errParseBool)
+		}
+		options = append(options, WithS3Versioned(b))
+	}
+	region := query.Get("region")
+	endpoint := query.Get("endpoint")
+	pathStyle := false
+	if v := query.Get("pathstyle"); len(v) > 0 {
+		b, errParseBool := strconv.ParseBool(v)
+		if errParseBool != nil {
+			return nil, fmt.Errorf("pathstyle %q is not valid: %w", v, errParseBool)
+		}
+		pathStyle = b
+	}
+	query.Del("acl")
+	query.Del("acquire")
+	query.Del("hold")
+	query.Del("versioned")
+	query.Del("endpoint")
+	query.Del("pathstyle")
+	query.Del("lockbucket")
+	query.Del("lockprefix")
+	query.Del("sse")
+	query.Del("kms")
+	query.Del("region")
+	if errQuery := checkQuery(query); errQuery != nil {
+		return nil, errQuery
+	}
+
+	bucket := uri.Host
+	key := strings.TrimPrefix(uri.Path, "/")
+	if len(bucket) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("s3 URI %q must have a bucket and a key", uri)
+	}
+
+	svcS3 := opts.S3Client
+	if svcS3 != nil && (len(region) > 0 || len(endpoint) > 0 || pathStyle) {
+		return nil, errors.New("region, endpoint and pathstyle can not be used with an s3 client")
+	}
+	if svcS3 == nil {
+		optFns := []func(*config.LoadOptions) error{}
+		if len(region) > 0 {
+			optFns = append(optFns, config.WithRegion(region))
+		}
+		awsCfg, errCfg := config.LoadDefaultConfig(ctx, optFns...)
+		if errCfg != nil {
+			return nil, fmt.Errorf("unable to load AWS configuration: %w", errCfg)
+		}
+		svcS3 = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			// S3 compatible stores such as MinIO usually need path-style addressing
+			if len(endpoint) > 0 {
+				o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+			}
+			o.UsePathStyle = pathStyle
+		})
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(opts.Node, bucket, key, svcS3, append(options, opts.Options...)...)
+	if errNew != nil {
+		return nil, errNew
+	}
+	if versioned == "auto" {
+		if errDetect := l.DetectS3Versioning(ctx); errDetect != nil {
+			return nil, errDetect
+		}
+	}
+	return l, nil
+}
+
+// checkQuery returns an error if there are any query parameters left that were not recognized
+func checkQuery(query url.Values) error {
+	for key := range query {
+		return fmt.Errorf("unsupported lock URI parameter %q", key)
+	}
+	return nil
+}
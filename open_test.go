@@ -0,0 +1,109 @@
+package safelock
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenFile(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+
+	l, errOpen := Open(context.Background(), "file:///tmp/file.txt?suffix=.lck&timeout=1m", OpenOptions{Node: 1, Fs: fs})
+	assert.NoError(t, errOpen)
+	assert.IsType(t, &FileLock{}, l)
+	assert.Equal(t, "/tmp/file.txt", l.(*FileLock).GetFilename())
+	assert.Equal(t, ".lck", l.GetLockSuffix())
+	assert.Equal(t, time.Minute, l.GetTimeout())
+	assert.Equal(t, uint16(1), l.GetNode())
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+
+	exists, errExists := afero.Exists(fs, "/tmp/file.txt.lck")
+	assert.NoError(t, errExists)
+	assert.True(t, exists)
+}
+
+func TestOpenS3(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{}
+
+	l, errOpen := Open(context.Background(), "s3://bucket/path/to/key?kms=kmsKeyArn", OpenOptions{S3Client: &svcS3})
+	assert.NoError(t, errOpen)
+	assert.IsType(t, &S3ObjectLock{}, l)
+	assert.Equal(t, "bucket", l.(*S3ObjectLock).GetS3Bucket())
+	assert.Equal(t, "path/to/key", l.(*S3ObjectLock).GetS3Key())
+	assert.Equal(t, "kmsKeyArn", l.(*S3ObjectLock).GetS3KMSKeyArn())
+	assert.Equal(t, DefaultSuffix, l.GetLockSuffix())
+	assert.Equal(t, "s3://bucket/path/to/key.lock", l.GetLockURI())
+
+	// Encryption
+	l, errOpen = Open(context.Background(), "s3://bucket/key?sse=sse-s3", OpenOptions{S3Client: &svcS3})
+	assert.NoError(t, errOpen)
+	assert.Equal(t, S3Encryption{Mode: S3EncryptionSSES3}, l.(*S3ObjectLock).GetS3Encryption())
+
+	// Options are applied after the query parameters
+	l, errOpen = Open(context.Background(), "s3://bucket/key?timeout=1m", OpenOptions{
+		S3Client: &svcS3,
+		Options:  []Option{WithTimeout(time.Hour)},
+	})
+	assert.NoError(t, errOpen)
+	assert.Equal(t, time.Hour, l.GetTimeout())
+}
+
+func TestOpenErrors(t *testing.T) {
+
+	opts := OpenOptions{Fs: afero.NewMemMapFs(), S3Client: &mocks.MockS3Client{}}
+
+	tests := []string{
+		"unknown://bucket/key",
+		"s3://bucket",
+		"s3:///key",
+		"s3://bucket/key?unknown=1",
+		"s3://bucket/key?sse=sse-c",
+		"s3://bucket/key?endpoint=http://localhost:9000",
+		"s3://bucket/key?pathstyle=maybe",
+		"s3://bucket/key?acl=unknown",
+		"s3://bucket/key?acquire=unknown",
+		"file://host/file.txt",
+		"file:///file.txt?timeout=invalid",
+		"file:///file.txt?kms=kmsKeyArn",
+		"file:///file.txt?suffix=",
+		"%",
+	}
+	for _, uri := range tests {
+		_, errOpen := Open(context.Background(), uri, opts)
+		assert.Error(t, errOpen, uri)
+	}
+}
+
+func TestRegisterScheme(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+
+	RegisterScheme("mem", func(ctx context.Context, uri *url.URL, query url.Values, opts OpenOptions) (SafeLockiface, error) {
+		l, errNew := NewFileLockWithOptions(opts.Node, uri.Host+uri.Path, fs, opts.Options...)
+		if errNew != nil {
+			return nil, errNew
+		}
+		return l, nil
+	})
+	defer func() {
+		openersMu.Lock()
+		delete(openers, "mem")
+		openersMu.Unlock()
+	}()
+
+	l, errOpen := Open(context.Background(), "MEM://dir/file.txt?suffix=.lck", OpenOptions{})
+	assert.NoError(t, errOpen)
+	assert.Equal(t, "dir/file.txt", l.(*FileLock).GetFilename())
+	assert.Equal(t, ".lck", l.GetLockSuffix())
+}
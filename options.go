@@ -0,0 +1,194 @@
+package safelock
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a lock when it is constructed
+// An option returns an error if its value is not valid or it is not supported by the lock.
+// Options apply to the locks of this package and to other locks that embed *SafeLock.
+type Option func(l configurable) error
+
+// configurable is the configuration of a lock set by options, which *SafeLock implements
+type configurable interface {
+	SetTimeout(time.Duration)
+	SetLockSuffix(string)
+	SetSessionID(uuid.UUID)
+	SetNodeName(string) error
+	SetBackoff(Backoff)
+	SetRetryBackoff(Backoff)
+	SetLogger(*slog.Logger)
+	SetMetrics(*Metrics)
+	SetTracerProvider(trace.TracerProvider)
+	AddObserver(LockObserver)
+	SetClock(Clock)
+	SetClockSkew(time.Duration)
+	SetMaxTTL(time.Duration)
+	SetOwnershipMode(OwnershipMode)
+}
+
+var _ configurable = (*SafeLock)(nil)
+
+// NewFileLockWithOptions creates a new instance of FileLock configured with the options
+func NewFileLockWithOptions(node uint16, filename string, fs afero.Fs, opts ...Option) (*FileLock, error) {
+	if len(filename) == 0 {
+		return nil, errors.New("a filename is required")
+	}
+	if fs == nil {
+		return nil, errors.New("a filesystem is required")
+	}
+
+	l := NewFileLock(node, filename, fs)
+	if errApply := applyOptions(l, opts); errApply != nil {
+		return nil, errApply
+	}
+	return l, nil
+}
+
+// NewS3ObjectLockWithOptions creates a new instance of S3ObjectLock configured with the options
+// Lock objects use the bucket's default encryption unless WithKMS or WithS3Encryption is given.
+func NewS3ObjectLockWithOptions(node uint16, s3bucket, s3key string, svcS3 LockS3Client, opts ...Option) (*S3ObjectLock, error) {
+	if len(s3bucket) == 0 {
+		return nil, errors.New("an s3 bucket is required")
+	}
+	if len(s3key) == 0 {
+		return nil, errors.New("an s3 key is required")
+	}
+	if svcS3 == nil {
+		return nil, errors.New("an s3 client is required")
+	}
+
+	l := NewS3ObjectLock(node, s3bucket, s3key, "", svcS3)
+	l.s3Encryption = S3Encryption{}
+	if errApply := applyOptions(l, opts); errApply != nil {
+		return nil, errApply
+	}
+	return l, nil
+}
+
+// applyOptions applies the options to the lock in order, stopping at the first error
+func applyOptions(l configurable, opts []Option) error {
+	for _, opt := range opts {
+		if errOption := opt(l); errOption != nil {
+			return fmt.Errorf("invalid lock option: %w", errOption)
+		}
+	}
+	return nil
+}
+
+// WithTimeout sets the timeout of the lock, after which it expires
+func WithTimeout(timeout time.Duration) Option {
+	return func(l configurable) error {
+		if timeout < 0 {
+			return fmt.Errorf("timeout %s must not be negative", timeout)
+		}
+		l.SetTimeout(timeout)
+		return nil
+	}
+}
+
+// WithSuffix sets the suffix appended to the name of the locked object to name the lock
+func WithSuffix(lockSuffix string) Option {
+	return func(l configurable) error {
+		if len(lockSuffix) == 0 {
+			return errors.New("lock suffix must not be empty")
+		}
+		l.SetLockSuffix(lockSuffix)
+		return nil
+	}
+}
+
+// WithID sets the session id of the lock
+func WithID(id uuid.UUID) Option {
+	return func(l configurable) error {
+		if id == uuid.Nil {
+			return errors.New("lock id must not be the nil UUID")
+		}
+		l.SetSessionID(id)
+		return nil
+	}
+}
+
+// WithNodeName sets the node name of the lock
+func WithNodeName(nodeName string) Option {
+	return func(l configurable) error {
+		return l.SetNodeName(nodeName)
+	}
+}
+
+// WithClock sets the clock used for lock timestamps, expiry and waiting
+func WithClock(clock Clock) Option {
+	return func(l configurable) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+		l.SetClock(clock)
+		return nil
+	}
+}
+
+// WithBackoff sets the backoff strategy used by WaitForLock
+func WithBackoff(backoff Backoff) Option {
+	return func(l configurable) error {
+		if backoff == nil {
+			return errors.New("backoff must not be nil")
+		}
+		l.SetBackoff(backoff)
+		return nil
+	}
+}
+
+// WithClockSkew sets the clock skew allowed when checking if a lock has expired
+func WithClockSkew(clockSkew time.Duration) Option {
+	return func(l configurable) error {
+		if clockSkew < 0 {
+			return fmt.Errorf("clock skew %s must not be negative", clockSkew)
+		}
+		l.SetClockSkew(clockSkew)
+		return nil
+	}
+}
+
+// WithMaxTTL sets the longest time a lock held by another session is honored
+func WithMaxTTL(maxTTL time.Duration) Option {
+	return func(l configurable) error {
+		if maxTTL < 0 {
+			return fmt.Errorf("max TTL %s must not be negative", maxTTL)
+		}
+		l.SetMaxTTL(maxTTL)
+		return nil
+	}
+}
+
+// WithOwnershipMode sets how ownership of the lock is checked when unlocking and taking over a lock
+func WithOwnershipMode(ownership OwnershipMode) Option {
+	return func(l configurable) error {
+		if ownership != OwnershipModeStrict && ownership != OwnershipModeNode {
+			return fmt.Errorf("ownership mode %q is not valid", ownership)
+		}
+		l.SetOwnershipMode(ownership)
+		return nil
+	}
+}
+
+// WithKMS encrypts S3 lock objects with SSE-KMS using the KMS key
+func WithKMS(s3KMSKeyArn string) Option {
+	return func(l configurable) error {
+		if len(s3KMSKeyArn) == 0 {
+			return errors.New("KMS key ARN must not be empty")
+		}
+		return WithS3Encryption(S3Encryption{Mode: S3EncryptionSSEKMS, KMSKeyID: s3KMSKeyArn})(l)
+	}
+}
+
+// WithoutKMS encrypts S3 lock objects with the bucket's default encryption
+func WithoutKMS() Option {
+	return WithS3Encryption(S3Encryption{Mode: S3EncryptionNone})
+}
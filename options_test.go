@@ -0,0 +1,128 @@
+package safelock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFileLockWithOptions(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+	id := uuid.New()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	backoff := NewConstantBackoff(time.Millisecond, 0)
+
+	l, errNew := NewFileLockWithOptions(1, "file.txt", fs,
+		WithTimeout(time.Minute),
+		WithSuffix(".lck"),
+		WithID(id),
+		WithNodeName("node"),
+		WithClock(clock),
+		WithBackoff(backoff),
+		WithClockSkew(time.Second),
+		WithMaxTTL(time.Hour),
+		WithOwnershipMode(OwnershipModeNode),
+	)
+	assert.NoError(t, errNew)
+	assert.Equal(t, time.Minute, l.GetTimeout())
+	assert.Equal(t, "file.txt.lck", l.GetLockFilename())
+	assert.Equal(t, id, l.GetSessionID())
+	assert.Equal(t, "node", l.GetNodeName())
+	assert.Equal(t, clock, l.GetClock())
+	assert.Equal(t, backoff, l.GetBackoff())
+	assert.Equal(t, time.Second, l.GetClockSkew())
+	assert.Equal(t, time.Hour, l.GetMaxTTL())
+	assert.Equal(t, OwnershipModeNode, l.GetOwnershipMode())
+}
+
+func TestNewFileLockWithOptionsErrors(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+
+	_, errNew := NewFileLockWithOptions(0, "", fs)
+	assert.Error(t, errNew)
+	_, errNew = NewFileLockWithOptions(0, "file.txt", nil)
+	assert.Error(t, errNew)
+
+	tests := []Option{
+		WithTimeout(-time.Second),
+		WithSuffix(""),
+		WithID(uuid.Nil),
+		WithClock(nil),
+		WithBackoff(nil),
+		WithClockSkew(-time.Second),
+		WithMaxTTL(-time.Second),
+		WithOwnershipMode("unknown"),
+		WithKMS("kmsKeyArn"),
+		WithoutKMS(),
+	}
+	for _, opt := range tests {
+		l, errNew := NewFileLockWithOptions(0, "file.txt", fs, opt)
+		assert.Error(t, errNew)
+		assert.Nil(t, l)
+	}
+}
+
+func TestNewS3ObjectLockWithOptions(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	// The bucket's default encryption is used without KMS
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithTimeout(time.Minute))
+	assert.NoError(t, errNew)
+	assert.Equal(t, time.Minute, l.GetTimeout())
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ServerSideEncryption(""), svcS3.PutObjectInput.ServerSideEncryption)
+	assert.Nil(t, svcS3.PutObjectInput.SSEKMSKeyId)
+
+	// KMS encryption with the key
+	l, errNew = NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithKMS("kmsKeyArn"))
+	assert.NoError(t, errNew)
+	assert.Equal(t, "kmsKeyArn", l.GetS3KMSKeyArn())
+
+	errLock = l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ServerSideEncryptionAwsKms, svcS3.PutObjectInput.ServerSideEncryption)
+	assert.Equal(t, "kmsKeyArn", *svcS3.PutObjectInput.SSEKMSKeyId)
+
+	// Errors
+	_, errNew = NewS3ObjectLockWithOptions(0, "", "key", &svcS3)
+	assert.Error(t, errNew)
+	_, errNew = NewS3ObjectLockWithOptions(0, "bucket", "", &svcS3)
+	assert.Error(t, errNew)
+	_, errNew = NewS3ObjectLockWithOptions(0, "bucket", "key", nil)
+	assert.Error(t, errNew)
+	_, errNew = NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithKMS(""))
+	assert.Error(t, errNew)
+}
+
+// embeddedLock is a lock of another package that embeds *SafeLock
+type embeddedLock struct {
+	*SafeLock
+}
+
+func TestOptionsEmbeddedLock(t *testing.T) {
+
+	l := embeddedLock{SafeLock: NewSafeLock(0)}
+	for _, opt := range []Option{WithTimeout(time.Minute), WithNodeName("node")} {
+		assert.NoError(t, opt(l))
+	}
+	assert.Equal(t, time.Minute, l.GetTimeout())
+	assert.Equal(t, "node", l.GetNodeName())
+
+	// Options of other backends are not supported
+	assert.Error(t, WithS3Versioned(true)(l))
+	assert.Error(t, WithLockDir("locks")(l))
+}
@@ -0,0 +1,171 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LockRegistry tracks the locks acquired through it so that they can all be released together,
+// for example when the process is shutting down
+type LockRegistry struct {
+	mu    sync.Mutex
+	locks map[SafeLockiface]struct{}
+
+	// exit is called after locks are released in response to a signal
+	exit func(os.Signal)
+}
+
+// NewLockRegistry creates a new instance of LockRegistry
+func NewLockRegistry() *LockRegistry {
+	return &LockRegistry{
+		locks: map[SafeLockiface]struct{}{},
+		exit:  reraiseSignal,
+	}
+}
+
+// Lock locks the lock and tracks it until it is unlocked through the registry
+func (r *LockRegistry) Lock(l SafeLockiface) error {
+	errLock := l.Lock()
+	if errLock != nil {
+		return errLock
+	}
+	r.Track(l)
+	return nil
+}
+
+// Unlock unlocks the lock and stops tracking it
+// A lock that is no longer held by its session, because it was removed or replaced, also stops being tracked.
+func (r *LockRegistry) Unlock(l SafeLockiface) error {
+	return r.UnlockContext(context.Background(), l)
+}
+
+// UnlockContext unlocks the lock and stops tracking it, the context cancels requests to the backend
+// A lock that is no longer held by its session, because it was removed or replaced, also stops being tracked.
+func (r *LockRegistry) UnlockContext(ctx context.Context, l SafeLockiface) error {
+	errUnlock := l.UnlockContext(ctx)
+	if errUnlock != nil && !isLostError(errUnlock) {
+		return errUnlock
+	}
+	r.Untrack(l)
+	return errUnlock
+}
+
+// isLostError returns true if Unlock failed because the lock is no longer held by the session
+// ErrNotLocked is only returned once the backend confirmed the lock does not exist, so a lock that
+// could not be checked, for example because requests were throttled, remains tracked.
+func isLostError(err error) bool {
+	return errors.Is(err, ErrNotLocked) || errors.Is(err, ErrWrongNode) || errors.Is(err, ErrWrongSession)
+}
+
+// Track starts tracking a lock that was acquired outside of the registry
+func (r *LockRegistry) Track(l SafeLockiface) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.locks[l] = struct{}{}
+}
+
+// Untrack stops tracking a lock without unlocking it
+func (r *LockRegistry) Untrack(l SafeLockiface) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.locks, l)
+}
+
+// Held returns the locks currently tracked by the registry
+func (r *LockRegistry) Held() []SafeLockiface {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	held := make([]SafeLockiface, 0, len(r.locks))
+	for l := range r.locks {
+		held = append(held, l)
+	}
+	return held
+}
+
+// ReleaseAll unlocks every tracked lock concurrently and returns once they are all released or the context is done
+// The context also cancels the requests to the backends. Locks that are no longer locked, or are now held by
+// another session, are considered released and stop being tracked. Locks that fail to unlock remain tracked.
+func (r *LockRegistry) ReleaseAll(ctx context.Context) error {
+	if errCtx := ctx.Err(); errCtx != nil {
+		return fmt.Errorf("unable to release all locks: %w", errCtx)
+	}
+	held := r.Held()
+
+	results := make(chan error, len(held))
+	for _, l := range held {
+		go func(l SafeLockiface) {
+			results <- r.UnlockContext(ctx, l)
+		}(l)
+	}
+
+	errs := []error{}
+	for range held {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unable to release all locks: %w", ctx.Err())
+		case errUnlock := <-results:
+			if errUnlock != nil && !isLostError(errUnlock) {
+				errs = append(errs, errUnlock)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to release %d of %d locks: %w", len(errs), len(held), errs[0])
+	}
+	return nil
+}
+
+// HandleSignals releases all tracked locks when one of the signals is received and then re-raises the
+// signal so the process exits as it would have without the handler. If no signals are given, SIGINT and
+// SIGTERM are handled. The timeout limits how long releasing may take. The returned function removes
+// the handler.
+func (r *LockRegistry) HandleSignals(timeout time.Duration, signals ...os.Signal) func() {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, signals...)
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case sig := <-c:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			// There is nobody to report the error to while shutting down
+			_ = r.ReleaseAll(ctx)
+			signal.Stop(c)
+			r.exit(sig)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// reraiseSignal sends the signal to this process again, which is expected to be handled by default
+// behavior once the registry stops handling it, or exits if the signal can't be sent
+func reraiseSignal(sig os.Signal) {
+	p, errFindProcess := os.FindProcess(os.Getpid())
+	if errFindProcess != nil {
+		os.Exit(1)
+	}
+	if errSignal := p.Signal(sig); errSignal != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+package safelock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockRegistry(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+	r := NewLockRegistry()
+
+	la := NewFileLock(0, "a.txt", fs)
+	lb := NewFileLock(0, "b.txt", fs)
+
+	assert.NoError(t, r.Lock(la))
+	assert.NoError(t, r.Lock(lb))
+	assert.Len(t, r.Held(), 2)
+
+	// Failing to lock does not track the lock
+	assert.Error(t, r.Lock(NewFileLock(1, "a.txt", fs)))
+	assert.Len(t, r.Held(), 2)
+
+	// Unlocking through the registry stops tracking
+	assert.NoError(t, r.Unlock(la))
+	assert.Len(t, r.Held(), 1)
+
+	// Locks released elsewhere are considered released
+	assert.NoError(t, r.Lock(la))
+	assert.NoError(t, la.Unlock())
+
+	assert.NoError(t, r.ReleaseAll(context.Background()))
+	assert.Len(t, r.Held(), 0)
+
+	lockState, errGetLockState := lb.GetLockState()
+	assert.NoError(t, errGetLockState)
+	assert.Equal(t, LockStateUnlocked, lockState)
+
+	// Locks taken over by another session are no longer tracked
+	assert.NoError(t, r.Lock(la))
+	other := NewFileLock(1, "a.txt", fs)
+	assert.NoError(t, other.ForceUnlock())
+	assert.NoError(t, other.Lock())
+
+	assert.NoError(t, r.ReleaseAll(context.Background()))
+	assert.Len(t, r.Held(), 0)
+	held, errIsHeld := other.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.True(t, held)
+}
+
+func TestLockRegistryReleaseAllErrors(t *testing.T) {
+
+	// Pretend that the lock exists but can't be deleted
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:  &s3.PutObjectOutput{},
+		HeadObjectOutput: &s3.HeadObjectOutput{},
+	}
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+
+	r := NewLockRegistry()
+	r.Track(l)
+
+	errReleaseAll := r.ReleaseAll(context.Background())
+	assert.Error(t, errReleaseAll)
+	assert.Len(t, r.Held(), 1)
+
+	// Nothing is released once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errReleaseAll = r.ReleaseAll(ctx)
+	assert.True(t, errors.Is(errReleaseAll, context.Canceled))
+
+	// The context cancels the retries of the unlock
+	l.SetRetryBackoff(NewMaxAttemptsBackoff(NewConstantBackoff(time.Hour, 0), 3))
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(l.GetLockBody()))}
+	svcS3.DeleteObjectFunc = func(params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "SlowDown"}
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errUnlock := r.UnlockContext(ctx, l)
+	assert.ErrorIs(t, errUnlock, context.DeadlineExceeded)
+	assert.Len(t, r.Held(), 1)
+
+	// A lock that can't be checked is not released
+	l.SetRetryBackoff(noDelayRetry(2))
+	svcS3.HeadObjectOutput = nil
+	svcS3.HeadObjectFunc = func(params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "SlowDown"}
+	}
+	errReleaseAll = r.ReleaseAll(context.Background())
+	assert.Error(t, errReleaseAll)
+	assert.NotErrorIs(t, errReleaseAll, ErrNotLocked)
+	assert.Len(t, r.Held(), 1)
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package safelock
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockRegistryHandleSignals(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+	r := NewLockRegistry()
+
+	exited := make(chan os.Signal, 1)
+	r.exit = func(sig os.Signal) {
+		exited <- sig
+	}
+
+	l := NewFileLock(0, "file.txt", fs)
+	assert.NoError(t, r.Lock(l))
+
+	stop := r.HandleSignals(time.Second, syscall.SIGUSR1)
+	defer stop()
+
+	p, errFindProcess := os.FindProcess(os.Getpid())
+	assert.NoError(t, errFindProcess)
+	assert.NoError(t, p.Signal(syscall.SIGUSR1))
+
+	select {
+	case sig := <-exited:
+		assert.Equal(t, syscall.SIGUSR1, sig)
+	case <-time.After(5 * time.Second):
+		t.Fatal("locks were not released after the signal")
+	}
+
+	lockState, errGetLockState := l.GetLockState()
+	assert.NoError(t, errGetLockState)
+	assert.Equal(t, LockStateUnlocked, lockState)
+}
@@ -0,0 +1,158 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// DefaultRetryBase is the default ceiling of the delay before the first retry of a failed backend operation
+	DefaultRetryBase time.Duration = 100 * time.Millisecond
+
+	// DefaultRetryMax is the default maximum delay between retries of a failed backend operation
+	DefaultRetryMax time.Duration = 2 * time.Second
+
+	// DefaultRetryAttempts is the default number of attempts made for a backend operation
+	DefaultRetryAttempts = 3
+)
+
+// NewDefaultRetryBackoff returns the default backoff strategy for retrying failed backend operations
+func NewDefaultRetryBackoff() Backoff {
+	return NewMaxAttemptsBackoff(NewExponentialBackoff(DefaultRetryBase, DefaultRetryMax), DefaultRetryAttempts)
+}
+
+// GetRetryBackoff returns the backoff strategy for retrying backend operations that fail with transient errors
+func (l *SafeLock) GetRetryBackoff() Backoff {
+	return l.retryBackoff
+}
+
+// SetRetryBackoff sets the backoff strategy for retrying backend operations that fail with transient errors
+// Use NewMaxAttemptsBackoff with a single attempt to disable retries. Setting nil restores the default.
+func (l *SafeLock) SetRetryBackoff(backoff Backoff) {
+	if backoff == nil {
+		backoff = NewDefaultRetryBackoff()
+	}
+	l.retryBackoff = backoff
+}
+
+// WithRetryBackoff sets the backoff strategy for retrying backend operations that fail with transient errors
+func WithRetryBackoff(backoff Backoff) Option {
+	return func(l configurable) error {
+		if backoff == nil {
+			return errors.New("retry backoff must not be nil")
+		}
+		l.SetRetryBackoff(backoff)
+		return nil
+	}
+}
+
+// retry calls op until it succeeds or fails with an error that is not retryable, waiting between
+// attempts according to the retry backoff. A failed attempt may still have taken effect, or
+// another session may have changed the lock since, so settled is called before every retry.
+// It returns true if the operation has already taken effect or an error to stop retrying
+// because retrying could clobber another session's lock.
+func (l *SafeLock) retry(ev *lockEvents, retryable func(error) bool, op func() error, settled func() (bool, error)) error {
+	backoff := l.GetRetryBackoff()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		errOp := op()
+		if errOp == nil || !retryable(errOp) {
+			return errOp
+		}
+
+		next, ok := backoff.Next(attempt, delay)
+		if !ok {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, errOp)
+		}
+		delay = next
+		ev.retrying(attempt, delay, errOp)
+		select {
+		case <-l.GetClock().After(delay):
+		case <-ev.ctx.Done():
+			return fmt.Errorf("stopped retrying after %d attempts: %w", attempt, errors.Join(ev.ctx.Err(), errOp))
+		}
+
+		done, errSettled := settled()
+		if errSettled != nil {
+			return errSettled
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// withoutSDKRetries disables the retries of the AWS SDK for a request, so writes of the lock are only retried
+// by SafeLock.retry, which checks whether a failed attempt took effect before repeating it
+func withoutSDKRetries(o *s3.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// isRetryableS3Error returns true if an S3 request failed with a transient error, such as
+// throttling, a 5xx response or a connection error
+func isRetryableS3Error(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// isRetryableFileError returns true if a filesystem operation failed with a transient error,
+// such as those returned by network filesystems
+func isRetryableFileError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent is the settled check for operations that can be repeated without affecting other sessions
+func idempotent() (bool, error) {
+	return false, nil
+}
+
+// lockSettled returns the settled check for retrying the write of a lock
+// The write has taken effect if the lock holds the written body, and retrying would
+// clobber another session's lock if the lock holds any other body.
+func lockSettled(ctx context.Context, current func(context.Context) (*lockBody, error), written *lockBody, errLocked error) func() (bool, error) {
+	return func() (bool, error) {
+		currentBody, errCurrent := current(ctx)
+		if errCurrent != nil {
+			return false, fmt.Errorf("unable to check the lock before retrying: %w", errCurrent)
+		}
+		if currentBody == nil {
+			return false, nil
+		}
+		if sameLockBody(currentBody, written) {
+			return true, nil
+		}
+		return false, errLocked
+	}
+}
+
+// unlockSettled returns the settled check for retrying the removal of a lock
+// The removal has taken effect if the lock no longer holds the removed body. If the lock
+// now holds another body, retrying would remove another session's lock so errReplaced is
+// returned, or the removal is treated as settled if errReplaced is nil. A lock whose body
+// could not be read before the removal is removed until it is gone.
+func unlockSettled(ctx context.Context, current func(context.Context) (*lockBody, error), removed *lockBody, errReplaced error) func() (bool, error) {
+	return func() (bool, error) {
+		currentBody, errCurrent := current(ctx)
+		if errCurrent != nil {
+			return false, fmt.Errorf("unable to check the lock before retrying: %w", errCurrent)
+		}
+		if currentBody == nil {
+			return true, nil
+		}
+		if removed == nil || sameLockBody(currentBody, removed) {
+			return false, nil
+		}
+		return errReplaced == nil, errReplaced
+	}
+}
@@ -0,0 +1,227 @@
+package safelock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyFs fails the first calls to OpenFile and Remove with a transient error
+type flakyFs struct {
+	afero.Fs
+	openFailures   int
+	removeFailures int
+	err            error
+}
+
+func (fs *flakyFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if fs.openFailures > 0 {
+		fs.openFailures--
+		return nil, &os.PathError{Op: "open", Path: name, Err: fs.err}
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (fs *flakyFs) Remove(name string) error {
+	if fs.removeFailures > 0 {
+		fs.removeFailures--
+		return &os.PathError{Op: "remove", Path: name, Err: fs.err}
+	}
+	return fs.Fs.Remove(name)
+}
+
+// noDelayRetry retries without waiting
+func noDelayRetry(attempts int) Backoff {
+	return NewMaxAttemptsBackoff(NewConstantBackoff(0, 0), attempts)
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	l := NewSafeLock(0)
+	assert.Equal(t, NewDefaultRetryBackoff(), l.GetRetryBackoff())
+
+	backoff := noDelayRetry(5)
+	assert.NoError(t, WithRetryBackoff(backoff)(l))
+	assert.Equal(t, backoff, l.GetRetryBackoff())
+	assert.Error(t, WithRetryBackoff(nil)(l))
+
+	// Restore the default
+	l.SetRetryBackoff(nil)
+	assert.Equal(t, NewDefaultRetryBackoff(), l.GetRetryBackoff())
+}
+
+func TestIsRetryableError(t *testing.T) {
+
+	assert.True(t, isRetryableS3Error(&smithy.GenericAPIError{Code: "SlowDown"}))
+	assert.False(t, isRetryableS3Error(&smithy.GenericAPIError{Code: "AccessDenied"}))
+	assert.False(t, isRetryableS3Error(errors.New("error from put object")))
+
+	assert.True(t, isRetryableFileError(&os.PathError{Op: "open", Path: "file", Err: syscall.EIO}))
+	assert.False(t, isRetryableFileError(&os.PathError{Op: "open", Path: "file", Err: syscall.EACCES}))
+	assert.False(t, isRetryableFileError(os.ErrNotExist))
+}
+
+func TestFileLockRetry(t *testing.T) {
+
+	fs := &flakyFs{Fs: afero.NewMemMapFs(), openFailures: 1, removeFailures: 1, err: syscall.EIO}
+	l, errNew := NewFileLockWithOptions(0, "file.txt", fs, WithRetryBackoff(noDelayRetry(3)))
+	require.NoError(t, errNew)
+
+	assert.NoError(t, l.Lock())
+	held, errIsHeld := l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.True(t, held)
+
+	assert.NoError(t, l.Unlock())
+	lockState, _ := l.GetLockState()
+	assert.Equal(t, LockStateUnlocked, lockState)
+
+	// Give up once the attempts are used up
+	fs.openFailures = 3
+	errLock := l.Lock()
+	assert.ErrorIs(t, errLock, syscall.EIO)
+	assert.Equal(t, 0, fs.openFailures)
+
+	// Errors that are not transient are not retried
+	fs.openFailures = 3
+	fs.err = syscall.EACCES
+	assert.ErrorIs(t, l.Lock(), syscall.EACCES)
+	assert.Equal(t, 2, fs.openFailures)
+}
+
+func TestS3ObjectLockRetry(t *testing.T) {
+
+	failures := 2
+	svcS3 := mocks.MockS3Client{
+		PutObjectFunc: func(params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			if failures > 0 {
+				failures--
+				return nil, &smithy.GenericAPIError{Code: "SlowDown"}
+			}
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithRetryBackoff(noDelayRetry(3)))
+	require.NoError(t, errNew)
+
+	assert.NoError(t, l.Lock())
+	assert.Equal(t, 0, failures)
+
+	// Every attempt writes the same body
+	body := new(bytes.Buffer)
+	_, errRead := body.ReadFrom(svcS3.PutObjectInput.Body)
+	assert.NoError(t, errRead)
+	parsed, errParse := parseLockBody(body.Bytes())
+	assert.NoError(t, errParse)
+	assert.Equal(t, l.GetSessionID(), parsed.id)
+
+	// Give up once the attempts are used up
+	failures = 3
+	errLock := l.Lock()
+	assert.Error(t, errLock)
+	var errAPI smithy.APIError
+	assert.ErrorAs(t, errLock, &errAPI)
+	assert.Equal(t, 0, failures)
+}
+
+func TestS3ObjectLockWithoutSDKRetries(t *testing.T) {
+
+	// The store has no lock object and fails every write with a transient error
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method]++
+		mu.Unlock()
+		switch r.Method {
+		case http.MethodPut, http.MethodDelete:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	sent := func(method string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[method]
+	}
+
+	svcS3 := s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: s3.EndpointResolverFromURL(server.URL),
+		UsePathStyle:     true,
+	})
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", svcS3, WithRetryBackoff(noDelayRetry(1)))
+	require.NoError(t, errNew)
+
+	// The SDK sends the write of the lock once, the lock retries it
+	errLock := l.Lock()
+	assert.Error(t, errLock)
+	assert.True(t, isRetryableS3Error(errLock))
+	assert.Equal(t, 1, sent(http.MethodPut))
+
+	errDelete := l.deleteLock(context.Background(), "")
+	assert.Error(t, errDelete)
+	assert.Equal(t, 1, sent(http.MethodDelete))
+}
+
+func TestRetrySettled(t *testing.T) {
+
+	l := NewSafeLock(0)
+	written, errParse := parseLockBody(l.GetLockBody())
+	require.NoError(t, errParse)
+	other, errParse := parseLockBody(NewSafeLock(1).GetLockBody())
+	require.NoError(t, errParse)
+	errLocked := errors.New("locked")
+
+	ctx := context.Background()
+	current := func(b *lockBody, err error) func(context.Context) (*lockBody, error) {
+		return func(context.Context) (*lockBody, error) { return b, err }
+	}
+
+	// A write is retried while there is no lock and settled once the lock holds the written body
+	done, errSettled := lockSettled(ctx, current(nil, nil), written, errLocked)()
+	assert.False(t, done)
+	assert.NoError(t, errSettled)
+	done, errSettled = lockSettled(ctx, current(written, nil), written, errLocked)()
+	assert.True(t, done)
+	assert.NoError(t, errSettled)
+	_, errSettled = lockSettled(ctx, current(other, nil), written, errLocked)()
+	assert.Equal(t, errLocked, errSettled)
+	_, errSettled = lockSettled(ctx, current(nil, syscall.EIO), written, errLocked)()
+	assert.ErrorIs(t, errSettled, syscall.EIO)
+
+	// A removal is retried while the lock holds the removed body and settled once it is gone
+	done, errSettled = unlockSettled(ctx, current(nil, nil), written, nil)()
+	assert.True(t, done)
+	assert.NoError(t, errSettled)
+	done, errSettled = unlockSettled(ctx, current(written, nil), written, nil)()
+	assert.False(t, done)
+	assert.NoError(t, errSettled)
+	done, errSettled = unlockSettled(ctx, current(other, nil), written, nil)()
+	assert.True(t, done)
+	assert.NoError(t, errSettled)
+	_, errSettled = unlockSettled(ctx, current(other, nil), written, errLocked)()
+	assert.Equal(t, errLocked, errSettled)
+	done, errSettled = unlockSettled(ctx, current(other, nil), nil, nil)()
+	assert.False(t, done)
+	assert.NoError(t, errSettled)
+}
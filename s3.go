@@ -3,10 +3,9 @@ package safelock
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"time"
@@ -23,138 +22,355 @@ import (
 type S3ObjectLock struct {
 	*SafeLock
 
-	s3Bucket    string
-	s3Key       string
-	s3KMSKeyArn string
+	s3Bucket     string
+	s3Key        string
+	s3Encryption S3Encryption
+	s3Tags       map[string]string
+	s3HolderTags bool
+
+	s3LockLocator S3LockLocator
+	s3ACL         types.ObjectCannedACL
+	s3AcquireMode S3AcquireMode
+	s3HoldMode    S3HoldMode
+
+	s3Versioned     bool
+	s3LockVersionID string
+	s3HeldVersionID string
 
 	svcS3 LockS3Client
+
+	svcSQS              LockSQSClient
+	sqsQueueURL         string
+	notificationBackoff Backoff
 }
 
+var _ SafeLockiface = (*S3ObjectLock)(nil)
+
 // NewS3ObjectLock creates a new instance of S3ObjectLock
+// The lock object is encrypted with SSE-KMS using the KMS key, or the AWS managed key if empty.
 func NewS3ObjectLock(node uint16, s3bucket, s3key, s3KMSKeyArn string, svcS3 LockS3Client) *S3ObjectLock {
 	return &S3ObjectLock{
-		SafeLock:    NewSafeLock(node),
-		s3Bucket:    s3bucket,
-		s3Key:       s3key,
-		s3KMSKeyArn: s3KMSKeyArn,
-		svcS3:       svcS3,
+		SafeLock: NewSafeLock(node),
+		s3Bucket: s3bucket,
+		s3Key:    s3key,
+		s3Encryption: S3Encryption{
+			Mode:     S3EncryptionSSEKMS,
+			KMSKeyID: s3KMSKeyArn,
+		},
+		s3LockLocator: S3LockBesideObject(),
+		s3ACL:         DefaultS3ACL,
+		s3AcquireMode: S3AcquireCheckThenPut,
+		s3HoldMode:    S3HoldNone,
+		svcS3:         svcS3,
 	}
 }
 
 // Lock will lock
+// Transient S3 errors are retried, see SetRetryBackoff.
 func (l *S3ObjectLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext will lock, the context carries the span of the operation and cancels requests to the backend
+func (l *S3ObjectLock) LockContext(ctx context.Context) error {
+	ev := l.newLockEvents(ctx, "Lock", l.GetLockURI())
+	start := l.GetClock().Now()
+	errLock := l.lock(ev)
+	ev.acquired(start, errLock)
+	return errLock
+}
+
+// lock writes the lock object, taking over a lock that has expired or, in OwnershipModeNode, was held by a prior session of this node
+func (l *S3ObjectLock) lock(ev *lockEvents) error {
+	ctx := ev.ctx
+
+	if errSessionID := l.checkSessionID(); errSessionID != nil {
+		return errSessionID
+	}
 
 	// Check first if the lock exists
-	// For S3ObjectLock the error is never used and the state can only be locked/unlocked
-	lockState, _ := l.GetLockState()
+	lockState, errLockState := l.GetLockStateContext(ctx)
+	if errLockState != nil {
+		return errLockState
+	}
 	if lockState == LockStateLocked {
-		// conditionally handle deadlock if the lock exists and is owned by a prior session of the same node
+		// conditionally handle deadlock if the lock exists and has expired or is owned by a prior session of the same node
 
 		// check the ownership of the lock
-		ownedNode, ownedSession, expired, err := l.lockStatus()
+		ownedNode, ownedSession, expired, held, err := l.lockStatus(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to check lock ownership: %v", err)
 		}
 
 		// release a deadlocked file lock
-		if (ownedNode && !ownedSession) || expired {
-			l.mu.Lock()
-			// remove file system lock
-			_, err := l.svcS3.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-				Bucket: &l.s3Bucket,
-				Key:    aws.String(l.GetLockPath()),
-			})
-			if err != nil {
-				l.mu.Unlock()
-				return err
+		if l.isAcquirable(ownedNode, ownedSession, expired) {
+			ev.takingOver(held, expired)
+			// the hold placed by the previous holder is released along with its lock
+			errRelease := l.retry(ev, isRetryableS3Error, func() error {
+				l.mu.Lock()
+				defer l.mu.Unlock()
+				return l.releaseObject(ctx, l.releasedVersion(held))
+			}, idempotent)
+			if errRelease != nil {
+				return errRelease
+			}
+			// remove file system lock, on versioned buckets only the version that was checked
+			errDelete := l.retry(ev, isRetryableS3Error, func() error {
+				l.mu.Lock()
+				defer l.mu.Unlock()
+				return l.deleteLock(ctx, held.versionID)
+			}, unlockSettled(ctx, l.currentLock, held, l.errLocked()))
+			if errDelete != nil {
+				return errDelete
 			}
-			l.mu.Unlock()
 		} else {
-			return fmt.Errorf("the object at %s is locked", l.GetObjectURI())
+			ev.holder = held
+			return l.errLocked()
 		}
 	}
 
-	// Lock after getting the lock state
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	// Write object to S3
+	// The same body is written by every attempt so a retry can tell whether an earlier attempt took effect
 	body := l.GetLockBody()
-	_, errPutObject := l.svcS3.PutObject(context.TODO(), &s3.PutObjectInput{
-		ACL:                  types.ObjectCannedACLPrivate,
-		Bucket:               &l.s3Bucket,
-		Key:                  aws.String(l.GetLockPath()),
-		Body:                 bytes.NewReader(body),
-		ContentType:          aws.String(http.DetectContentType(body)),
-		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
-		SSEKMSKeyId:          &l.s3KMSKeyArn,
-	})
+
+	// the holder is also recorded in the metadata, and optionally the tags, so it can be read without the body
+	written, errParse := parseLockBody(body)
+	if errParse != nil {
+		return errParse
+	}
+
+	// the version of the locked object that is held is recorded, so whoever removes the lock can release it
+	if l.holdsObject() {
+		errVersion := l.retry(ev, isRetryableS3Error, func() error {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			var errObjectVersion error
+			written.heldVersionID, errObjectVersion = l.objectVersion(ctx)
+			return errObjectVersion
+		}, idempotent)
+		if errVersion != nil {
+			return errVersion
+		}
+	}
+	metadata := lockMetadata(written, lockHostname())
+
+	putObjectInput := &s3.PutObjectInput{
+		ACL:         l.s3ACL,
+		Bucket:      aws.String(l.GetLockBucket()),
+		Key:         aws.String(l.GetLockPath()),
+		ContentType: aws.String(http.DetectContentType(body)),
+		Metadata:    metadata,
+	}
+	holderMetadata := metadata
+	if !l.s3HolderTags {
+		holderMetadata = nil
+	}
+	if tagging := lockTagging(holderMetadata, l.s3Tags); len(tagging) > 0 {
+		putObjectInput.Tagging = aws.String(tagging)
+	}
+	if errEncryption := l.s3Encryption.applyPutObject(putObjectInput); errEncryption != nil {
+		return errEncryption
+	}
+	optFns := []func(*s3.Options){withoutSDKRetries}
+	if l.s3AcquireMode == S3AcquireConditionalPut {
+		// another session wrote the lock object after the lock state was checked
+		optFns = append(optFns, withIfNoneMatch)
+	}
+
+	// an attempt that took effect although it failed is recognized by the lock's current version
+	versionID := ""
+	current := func(ctx context.Context) (*lockBody, error) {
+		currentBody, errCurrent := l.currentLock(ctx)
+		if currentBody != nil {
+			versionID = currentBody.versionID
+		}
+		return currentBody, errCurrent
+	}
+	errPutObject := l.retry(ev, isRetryableS3Error, func() error {
+		// Lock after getting the lock state
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		putObjectInput.Body = bytes.NewReader(body)
+		putObjectOutput, errPutObject := l.svcS3.PutObject(ctx, putObjectInput, optFns...)
+		if errPutObject != nil {
+			return errPutObject
+		}
+		versionID = l.versionID(putObjectOutput.VersionId)
+		return nil
+	}, lockSettled(ctx, current, written, l.errLocked()))
 	if errPutObject != nil {
+		if isPreconditionFailed(errPutObject) {
+			return l.errLocked()
+		}
 		return errPutObject
 	}
+
+	l.mu.Lock()
+	l.s3LockVersionID = versionID
+	l.s3HeldVersionID = written.heldVersionID
+	l.mu.Unlock()
+
+	// S3 enforces the lock on the locked object if it is held
+	errHold := l.retry(ev, isRetryableS3Error, func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.holdObject(ctx)
+	}, idempotent)
+	if errHold != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		_ = l.deleteLock(context.WithoutCancel(ctx), l.s3LockVersionID)
+		l.s3LockVersionID = ""
+		l.s3HeldVersionID = ""
+		return errHold
+	}
 	return nil
 }
 
 // Unlock will unlock
+// Transient S3 errors are retried, see SetRetryBackoff.
 func (l *S3ObjectLock) Unlock() error {
+	return l.UnlockContext(context.Background())
+}
+
+// UnlockContext will unlock, the context carries the span of the operation and cancels requests to the backend
+func (l *S3ObjectLock) UnlockContext(ctx context.Context) error {
+	ev := l.newLockEvents(ctx, "Unlock", l.GetLockURI())
+	held, errUnlock := l.unlock(ev)
+	ev.released(held, false, errUnlock)
+	return errUnlock
+}
+
+// unlock removes the lock if it is owned by this session and returns the lock that was removed
+func (l *S3ObjectLock) unlock(ev *lockEvents) (*lockBody, error) {
+	ctx := ev.ctx
 
 	// Check first if the lock exists
-	// For S3ObjectLock the error is never used and the state can only be locked/unlocked
-	lockState, _ := l.GetLockState()
+	lockState, errLockState := l.GetLockStateContext(ctx)
+	if errLockState != nil {
+		return nil, errLockState
+	}
 	if lockState == LockStateUnlocked {
-		return fmt.Errorf("the object at %s is not locked", l.GetObjectURI())
+		return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetObjectURI(), ErrNotLocked)
 	}
 
 	// Validate that the lock belongs to this code
-	ownedNode, _, expired, errIsSameLock := l.lockStatus()
+	ownedNode, ownedSession, expired, held, errIsSameLock := l.lockStatus(ctx)
 	if errIsSameLock != nil {
-		return fmt.Errorf("unable to determine if lock is the same lock: %w", errIsSameLock)
+		return nil, fmt.Errorf("unable to determine if lock is the same lock: %w", errIsSameLock)
 	}
 
-	if !ownedNode && !expired {
-		return fmt.Errorf("the existing lock is not managed by this process")
+	if errOwnership := l.checkOwnership(ownedNode, ownedSession, expired); errOwnership != nil {
+		return held, errOwnership
 	}
 
-	// Lock after verifying the state and lock contents
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	// Remove object from S3
-	_, errDeleteObject := l.svcS3.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: &l.s3Bucket,
-		Key:    aws.String(l.GetLockPath()),
-	})
-	if errDeleteObject != nil {
-		return errDeleteObject
-	}
-	return nil
+	return held, l.removeLock(ev, held)
 }
 
 // ForceUnlock will unlock despite ownership
+// Transient S3 errors are retried, see SetRetryBackoff.
 func (l *S3ObjectLock) ForceUnlock() error {
+	return l.ForceUnlockContext(context.Background())
+}
+
+// ForceUnlockContext will unlock despite ownership, the context carries the span of the operation and
+// cancels requests to the backend
+func (l *S3ObjectLock) ForceUnlockContext(ctx context.Context) error {
+	ev := l.newLockEvents(ctx, "ForceUnlock", l.GetLockURI())
+	held, errUnlock := l.forceUnlock(ev)
+	ev.released(held, true, errUnlock)
+	return errUnlock
+}
+
+// forceUnlock removes the lock and returns the lock that was removed
+func (l *S3ObjectLock) forceUnlock(ev *lockEvents) (*lockBody, error) {
+	ctx := ev.ctx
 
 	// Check first if the lock exists
-	// For S3ObjectLock the error is never used and the state can only be locked/unlocked
-	lockState, _ := l.GetLockState()
-	if lockState == LockStateUnlocked {
-		return fmt.Errorf("the object at %s is not locked", l.GetObjectURI())
+	var headObjectOutput *s3.HeadObjectOutput
+	errHeadObject := l.retry(ev, isRetryableS3Error, func() error {
+		var errHead error
+		headObjectOutput, errHead = l.headLock(ctx)
+		return errHead
+	}, idempotent)
+	if errHeadObject != nil {
+		if isNotFound(errHeadObject) {
+			return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetObjectURI(), ErrNotLocked)
+		}
+		return nil, l.errLockState(errHeadObject)
 	}
 
-	// Lock after verifying the state and lock contents
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	// The lock is removed even if it can't be read
+	held, _, errReadLock := l.readLock(ctx)
+	if errReadLock != nil {
+		held = nil
+	}
+	if held == nil && l.s3Versioned {
+		held = &lockBody{versionID: l.versionID(headObjectOutput.VersionId)}
+	}
 
-	// Remove object from S3
-	_, errDeleteObject := l.svcS3.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: &l.s3Bucket,
-		Key:    aws.String(l.GetLockPath()),
-	})
+	return held, l.removeLock(ev, held)
+}
+
+// removeLock releases the hold on the locked object and removes the lock object
+// On versioned buckets only the version that was checked is removed.
+func (l *S3ObjectLock) removeLock(ev *lockEvents, held *lockBody) error {
+	ctx := ev.ctx
+	versionID := ""
+	if held != nil {
+		versionID = held.versionID
+	}
+
+	// The hold is released first so a failure leaves the lock in place
+	errRelease := l.retry(ev, isRetryableS3Error, func() error {
+		// Lock after verifying the state and lock contents
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.releaseObject(ctx, l.releasedVersion(held))
+	}, idempotent)
+	if errRelease != nil {
+		return errRelease
+	}
+
+	// Remove object from S3, a lock that was replaced since it was checked has been released
+	errDeleteObject := l.retry(ev, isRetryableS3Error, func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.deleteLock(ctx, versionID)
+	}, unlockSettled(ctx, l.currentLock, held, nil))
 	if errDeleteObject != nil {
 		return errDeleteObject
 	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.s3LockVersionID = ""
 	return nil
 }
 
+// currentLock reads the lock object before retrying an operation, returning nil if there is no lock object
+func (l *S3ObjectLock) currentLock(ctx context.Context) (*lockBody, error) {
+	lockBody, _, errReadLock := l.readLock(ctx)
+	if errReadLock != nil {
+		if isNotFound(errReadLock) {
+			return nil, nil
+		}
+		return nil, errReadLock
+	}
+	return lockBody, nil
+}
+
+// errLocked returns the error for a lock held by another session
+func (l *S3ObjectLock) errLocked() error {
+	return fmt.Errorf("the object at %s is locked: %w", l.GetObjectURI(), ErrLocked)
+}
+
+// errLockState returns the error for a lock whose state could not be read
+func (l *S3ObjectLock) errLockState(err error) error {
+	return fmt.Errorf("unable to check the lock at %s: %w", l.GetLockURI(), err)
+}
+
 // GetS3Bucket will return the s3 bucket for the lock
 func (l *S3ObjectLock) GetS3Bucket() string {
 	return l.s3Bucket
@@ -167,7 +383,7 @@ func (l *S3ObjectLock) GetS3Key() string {
 
 // GetS3KMSKeyArn will return the s3 KMS key Arn for the lock
 func (l *S3ObjectLock) GetS3KMSKeyArn() string {
-	return l.s3KMSKeyArn
+	return l.s3Encryption.KMSKeyID
 }
 
 // GetS3ObjectURI will return the s3 object URI for the file being locked
@@ -184,109 +400,226 @@ func (l *S3ObjectLock) GetObjectURI() string {
 func (l *S3ObjectLock) GetLockURI() string {
 	uri := url.URL{
 		Scheme: "s3",
-		Host:   l.GetS3Bucket(),
+		Host:   l.GetLockBucket(),
 		Path:   l.GetLockPath(),
 	}
 	return uri.String()
 }
 
+// GetLockBucket will return the s3 bucket for the lock object
+func (l *S3ObjectLock) GetLockBucket() string {
+	lockBucket, _ := l.s3LockLocator(l.GetS3Bucket(), l.GetS3Key(), l.GetLockSuffix())
+	return lockBucket
+}
+
 // GetLockPath will return the s3 key for the lock object
 func (l *S3ObjectLock) GetLockPath() string {
-	lockPath := l.GetS3Key() + l.GetLockSuffix()
+	_, lockPath := l.s3LockLocator(l.GetS3Bucket(), l.GetS3Key(), l.GetLockSuffix())
 	return lockPath
 }
 
 // GetLockState returns the lock's state
 func (l *S3ObjectLock) GetLockState() (LockState, error) {
+	return l.GetLockStateContext(context.Background())
+}
+
+// GetLockStateContext returns the lock's state, the context carries the span of the operation and
+// cancels requests to the backend
+func (l *S3ObjectLock) GetLockStateContext(ctx context.Context) (LockState, error) {
+	ev := l.newLockEvents(ctx, "GetLockState", l.GetLockURI())
+	errHeadObject := l.retry(ev, isRetryableS3Error, func() error {
+		_, errHead := l.headLock(ev.ctx)
+		return errHead
+	}, idempotent)
+	switch {
+	case errHeadObject == nil:
+		return ev.checked(LockStateLocked, nil)
+	case isNotFound(errHeadObject):
+		// the lock object doesn't exist
+		return ev.checked(LockStateUnlocked, nil)
+	default:
+		// any other error, such as access denied, says nothing about whether the lock exists
+		return ev.checked(LockStateUnknown, l.errLockState(errHeadObject))
+	}
+}
+
+// lockStatus load the current state of the lock
+// Returns
+//
+//	nodeOwned 			- bool, whether the lock is owned by this node
+//	sessionOwned 		- bool, whether the lock is owned byt his session
+//	expired 			- bool, whether the lock has passed its expiration
+//	lockBody 			- *lockBody, the lock that was checked, including its version on versioned buckets
+func (l *S3ObjectLock) lockStatus(ctx context.Context) (bool, bool, bool, *lockBody, error) {
+	lockBody, modified, errReadLock := l.readLock(ctx)
+	if errReadLock != nil {
+		return false, false, false, nil, errReadLock
+	}
+
+	ownedNode, ownedSession := l.lockOwnership(lockBody)
+	expired := l.isExpired(lockBody, modified)
+
+	// a lock object replaced since this session wrote it is not owned by this session
+	if written := l.GetS3LockVersionID(); ownedSession && len(written) > 0 && len(lockBody.versionID) > 0 {
+		ownedSession = lockBody.versionID == written
+	}
+
+	return ownedNode, ownedSession, expired, lockBody, nil
+}
+
+// headLock returns the metadata of the lock object
+func (l *S3ObjectLock) headLock(ctx context.Context) (*s3.HeadObjectOutput, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	_, errHeadObject := l.svcS3.HeadObject(context.TODO(), &s3.HeadObjectInput{
-		Bucket: &l.s3Bucket,
+	headObjectInput := &s3.HeadObjectInput{
+		Bucket: aws.String(l.GetLockBucket()),
 		Key:    aws.String(l.GetLockPath()),
-	})
-	if errHeadObject != nil {
-		// Throw away the error here because it means the file doesn't exist
-		// Assume that API errors also mean state is unlocked
-		return LockStateUnlocked, nil
 	}
-	return LockStateLocked, nil
+	l.s3Encryption.applyHeadObject(headObjectInput)
+	return l.svcS3.HeadObject(ctx, headObjectInput)
 }
 
-// lockStatus load the current state of the lock
-// Returns
-// 		nodeOwned 			- bool, whether the lock is owned by this node
-//		sessionOwned 		- bool, whether the lock is owned byt his session
-//		expired 			- bool, whether the lock has passed its expiration
-func (l *S3ObjectLock) lockStatus() (bool, bool, bool, error) {
+// readLock reads and parses the lock object
+// The holder is read from the object's metadata if it was recorded there, otherwise from the body.
+// Returns the parsed lock body and the time the lock was last modified
+func (l *S3ObjectLock) readLock(ctx context.Context) (*lockBody, time.Time, error) {
+	if headObjectOutput, errHeadObject := l.headLock(ctx); errHeadObject == nil {
+		if lockBody, ok := parseLockMetadata(headObjectOutput.Metadata); ok {
+			lockBody.versionID = l.versionID(headObjectOutput.VersionId)
+			modified := lockBody.timestamp
+			if headObjectOutput.LastModified != nil {
+				modified = *headObjectOutput.LastModified
+			}
+			return lockBody, modified, nil
+		}
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	getObjectOutput, errGetObject := l.svcS3.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: &l.s3Bucket,
+	getObjectInput := &s3.GetObjectInput{
+		Bucket: aws.String(l.GetLockBucket()),
 		Key:    aws.String(l.GetLockPath()),
-	})
+	}
+	l.s3Encryption.applyGetObject(getObjectInput)
+	getObjectOutput, errGetObject := l.svcS3.GetObject(ctx, getObjectInput)
 	if errGetObject != nil {
-		return false, false, false, errGetObject
+		return nil, time.Time{}, errGetObject
 	}
 	body, errRead := ioutil.ReadAll(getObjectOutput.Body)
 	if errRead != nil {
-		return false, false, false, errRead
+		return nil, time.Time{}, errRead
+	}
+
+	// parse the body into the node, id and timestamps
+	lockBody, errParse := parseLockBody(body)
+	if errParse != nil {
+		return nil, time.Time{}, errParse
 	}
+	lockBody.versionID = l.versionID(getObjectOutput.VersionId)
 
-	// split the body into the node and id
-	parts := bytes.Split(body, []byte("__::__"))
-	if len(parts) != 3 {
-		return false, false, false, fmt.Errorf("incompatible lock file format")
+	// the last modified time recorded by S3 is used for expiration, the
+	// timestamp in the lock file is only a fallback
+	modified := lockBody.timestamp
+	if getObjectOutput.LastModified != nil {
+		modified = *getObjectOutput.LastModified
 	}
 
-	// set the default value for expiration to false
-	expired := false
+	return lockBody, modified, nil
+}
+
+// GetOwner returns the current holder of the lock
+func (l *S3ObjectLock) GetOwner() (*LockOwner, error) {
+	return l.GetOwnerContext(context.Background())
+}
 
-	// handle timestamp if there is a configured timeout on the lock
-	if l.timeout > 0 {
-		// decode the timestamp from the third position in the lock file
-		ts := time.Unix(0, int64(binary.LittleEndian.Uint64(parts[2])))
+// GetOwnerContext returns the current holder of the lock, the context carries the span of the
+// operation and cancels requests to the backend
+// ErrNotLocked is only returned if the lock object is not found, other errors are returned as is.
+func (l *S3ObjectLock) GetOwnerContext(ctx context.Context) (*LockOwner, error) {
+	lockState, errLockState := l.GetLockStateContext(ctx)
+	if errLockState != nil {
+		return nil, errLockState
+	}
+	if lockState == LockStateUnlocked {
+		return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetObjectURI(), ErrNotLocked)
+	}
 
-		// update expired with the expiration status
-		expired = time.Since(ts) > l.timeout
+	lockBody, modified, errReadLock := l.readLock(ctx)
+	if errReadLock != nil {
+		// the lock object was removed since its state was checked
+		if isNotFound(errReadLock) {
+			return nil, fmt.Errorf("the object at %s is not locked: %w", l.GetObjectURI(), ErrNotLocked)
+		}
+		return nil, fmt.Errorf("unable to read lock: %w", errReadLock)
 	}
 
-	return bytes.Equal(parts[0], l.GetNodeBytes()), bytes.Equal(parts[1], l.GetIDBytes()), expired, nil
+	return l.newLockOwner(lockBody, modified), nil
 }
 
-// WaitForLock waits until an object is no longer locked or cancels based on a timeout
+// IsHeld returns true if the lock is held by this session and has not expired
+func (l *S3ObjectLock) IsHeld() (bool, error) {
+	return l.IsHeldContext(context.Background())
+}
+
+// IsHeldContext returns true if the lock is held by this session and has not expired, the context
+// carries the span of the operation and cancels requests to the backend
+// The lock is only reported lost if it is not found or held by another session, errors checking it are returned.
+func (l *S3ObjectLock) IsHeldContext(ctx context.Context) (bool, error) {
+	owner, errGetOwner := l.GetOwnerContext(ctx)
+	if errGetOwner != nil {
+		if errors.Is(errGetOwner, ErrNotLocked) {
+			l.lost(ctx, l.GetLockURI(), nil, errGetOwner)
+			return false, nil
+		}
+		return false, errGetOwner
+	}
+	if !l.isOwner(owner) {
+		l.lost(ctx, l.GetLockURI(), owner, nil)
+		return false, nil
+	}
+	return true, nil
+}
+
+// WaitForLock waits until an object is no longer locked, or the existing lock has expired or, in
+// OwnershipModeNode, belongs to a prior session of this node, or cancels based on a timeout
+// If a notification queue is configured the lock state is checked whenever the lock object
+// is removed and otherwise only polled according to the notification backoff.
 func (l *S3ObjectLock) WaitForLock(timeout time.Duration) error {
+	return l.WaitForLockContext(context.Background(), timeout)
+}
+
+// WaitForLockContext waits until the lock is available, the context carries the span of the
+// operation and cancels waiting
+func (l *S3ObjectLock) WaitForLockContext(ctx context.Context, timeout time.Duration) error {
 	// Do not lock/unlock the struct here or it will block getting the lock state
+	ev := l.newLockEvents(ctx, "WaitForLock", l.GetLockURI())
+
+	backoff := l.GetBackoff()
+	var wake <-chan struct{}
+	if l.svcSQS != nil {
+		notifyCtx, cancel := context.WithCancel(ev.ctx)
+		defer cancel()
+		backoff = l.GetNotificationBackoff()
+		wake = l.receiveNotifications(notifyCtx)
+	}
 
-	// create variable to hold the context
-	var ctx context.Context
-	var cancel context.CancelFunc
-
-	// conditionally configure the context with a timeout
-	if timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), timeout)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
-	}
-
-	defer cancel()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("unable to obtain lock after %s: %w", l.GetTimeout(), ctx.Err())
-		default:
-			// For S3 there will never be an error when getting lock state
-			lockState, _ := l.GetLockState()
-			switch lockState {
-			case LockStateUnlocked:
-				return nil
-			case LockStateUnknown, LockStateLocked:
-				// Add jitter to the sleep of 1 second
-				r := rand.Intn(100)
-				time.Sleep(1*time.Second + time.Duration(r)*time.Millisecond)
-			}
+	return l.waitFor(ev, timeout, backoff, func() (bool, error) {
+		lockState, errLockState := l.GetLockStateContext(ev.ctx)
+		if errLockState != nil {
+			return false, errLockState
 		}
-	}
+		if lockState == LockStateUnlocked {
+			return true, nil
+		}
+
+		// Lock will take over the existing lock if it is stale
+		ownedNode, ownedSession, expired, _, errLockStatus := l.lockStatus(ev.ctx)
+		if errLockStatus != nil {
+			// The lock may have been removed or replaced since the state was checked
+			return false, nil
+		}
+		return l.isAcquirable(ownedNode, ownedSession, expired), nil
+	}, wake)
 }
@@ -0,0 +1,200 @@
+package safelock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/google/uuid"
+)
+
+// S3AcquireMode is how S3ObjectLock writes the lock object when locking
+type S3AcquireMode string
+
+const (
+	// S3AcquireCheckThenPut checks that the lock object does not exist and then writes it
+	// Two sessions that check at the same time may both acquire the lock.
+	S3AcquireCheckThenPut S3AcquireMode = "check-then-put"
+	// S3AcquireConditionalPut writes the lock object only if it does not exist using If-None-Match,
+	// so only one of the sessions that check at the same time acquires the lock.
+	S3AcquireConditionalPut S3AcquireMode = "conditional-put"
+)
+
+// DefaultS3ACL is the default canned ACL of lock objects
+const DefaultS3ACL = types.ObjectCannedACLPrivate
+
+// S3Capabilities are the features supported by an S3 compatible store
+type S3Capabilities struct {
+	// ConditionalPut is whether the store rejects writes with If-None-Match when the object exists
+	ConditionalPut bool
+	// ACL is whether the store accepts canned ACLs on objects
+	// Buckets with ACLs disabled reject them.
+	ACL bool
+}
+
+// GetS3ACL returns the canned ACL of the lock object, empty if no ACL is sent
+func (l *S3ObjectLock) GetS3ACL() types.ObjectCannedACL {
+	return l.s3ACL
+}
+
+// SetS3ACL sets the canned ACL of the lock object, empty to send no ACL
+func (l *S3ObjectLock) SetS3ACL(acl types.ObjectCannedACL) {
+	l.s3ACL = acl
+}
+
+// GetS3AcquireMode returns how the lock object is written when locking
+func (l *S3ObjectLock) GetS3AcquireMode() S3AcquireMode {
+	return l.s3AcquireMode
+}
+
+// SetS3AcquireMode sets how the lock object is written when locking
+func (l *S3ObjectLock) SetS3AcquireMode(mode S3AcquireMode) error {
+	if mode != S3AcquireCheckThenPut && mode != S3AcquireConditionalPut {
+		return fmt.Errorf("s3 acquire mode %q is not valid", mode)
+	}
+	l.s3AcquireMode = mode
+	return nil
+}
+
+// ApplyS3Capabilities selects the strongest acquire mode and the ACL supported by the store
+func (l *S3ObjectLock) ApplyS3Capabilities(capabilities *S3Capabilities) {
+	if capabilities.ConditionalPut {
+		l.s3AcquireMode = S3AcquireConditionalPut
+	} else {
+		l.s3AcquireMode = S3AcquireCheckThenPut
+	}
+	if !capabilities.ACL {
+		l.s3ACL = ""
+	}
+}
+
+// ProbeS3Capabilities detects the features supported by the store of the lock object
+// The probe writes and removes a temporary object next to the lock object, using the lock's
+// encryption, so it needs the same permissions as locking.
+func (l *S3ObjectLock) ProbeS3Capabilities(ctx context.Context) (*S3Capabilities, error) {
+	capabilities := &S3Capabilities{}
+
+	key := l.GetLockPath() + ".probe-" + uuid.NewString()
+	put := func(acl types.ObjectCannedACL, optFns ...func(*s3.Options)) error {
+		putObjectInput := &s3.PutObjectInput{
+			ACL:    acl,
+			Bucket: aws.String(l.GetLockBucket()),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("probe")),
+		}
+		if errEncryption := l.s3Encryption.applyPutObject(putObjectInput); errEncryption != nil {
+			return errEncryption
+		}
+		_, errPutObject := l.svcS3.PutObject(ctx, putObjectInput, optFns...)
+		return errPutObject
+	}
+	// Buckets with ACLs disabled reject any ACL other than bucket-owner-full-control
+	acl := l.s3ACL
+	if len(acl) == 0 {
+		acl = DefaultS3ACL
+	}
+	if errPut := put(acl); errPut == nil {
+		capabilities.ACL = true
+	} else if errPut = put(""); errPut != nil {
+		return nil, fmt.Errorf("unable to write probe object: %w", errPut)
+	}
+
+	defer func() {
+		_, _ = l.svcS3.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(l.GetLockBucket()),
+			Key:    aws.String(key),
+		})
+	}()
+
+	// Stores that support conditional writes reject the second write of the same object
+	errPut := put("", withIfNoneMatch)
+	capabilities.ConditionalPut = isPreconditionFailed(errPut)
+
+	return capabilities, nil
+}
+
+// WithS3ACL sets the canned ACL of S3 lock objects, empty to send no ACL
+func WithS3ACL(acl types.ObjectCannedACL) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 ACLs are not supported by %T", l)
+		}
+		if len(acl) > 0 && !s3CannedACLValid(acl) {
+			return fmt.Errorf("s3 canned ACL %q is not valid", acl)
+		}
+		s3l.SetS3ACL(acl)
+		return nil
+	}
+}
+
+// WithS3AcquireMode sets how S3 lock objects are written when locking
+func WithS3AcquireMode(mode S3AcquireMode) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 acquire modes are not supported by %T", l)
+		}
+		return s3l.SetS3AcquireMode(mode)
+	}
+}
+
+// s3CannedACLValid returns true if the ACL is a known canned ACL
+func s3CannedACLValid(acl types.ObjectCannedACL) bool {
+	for _, v := range acl.Values() {
+		if v == acl {
+			return true
+		}
+	}
+	return false
+}
+
+// withIfNoneMatch makes a PutObject request fail if the object already exists
+func withIfNoneMatch(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", "*"))
+}
+
+// isPreconditionFailed returns true if the error is S3 rejecting a conditional write
+// because the object exists, or because of a concurrent conditional write.
+func isPreconditionFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	var respErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == 412
+	}
+	return false
+}
+
+// isNotFound returns true if an S3 request failed because the object does not exist
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey":
+			return true
+		}
+	}
+	var respErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == 404
+	}
+	return false
+}
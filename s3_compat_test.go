@@ -0,0 +1,132 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockS3Store is a minimal S3 compatible store for probing capabilities
+type mockS3Store struct {
+	objects        map[string]bool
+	acl            bool
+	conditionalPut bool
+}
+
+func (m *mockS3Store) PutObject(params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if len(params.ACL) > 0 && !m.acl {
+		return nil, &smithy.GenericAPIError{Code: "AccessControlListNotSupported"}
+	}
+	o := s3.Options{}
+	for _, fn := range optFns {
+		fn(&o)
+	}
+	// the only API option used is If-None-Match
+	if len(o.APIOptions) > 0 && m.conditionalPut && m.objects[*params.Key] {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	m.objects[*params.Key] = true
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3ObjectLockProbeS3Capabilities(t *testing.T) {
+
+	tests := []S3Capabilities{
+		{ConditionalPut: true, ACL: true},
+		{ConditionalPut: true, ACL: false},
+		{ConditionalPut: false, ACL: true},
+		{ConditionalPut: false, ACL: false},
+	}
+	for _, expected := range tests {
+		store := &mockS3Store{objects: map[string]bool{}, acl: expected.ACL, conditionalPut: expected.ConditionalPut}
+		svcS3 := mocks.MockS3Client{
+			DeleteObjectOutput: &s3.DeleteObjectOutput{},
+			PutObjectFunc:      store.PutObject,
+		}
+
+		l := NewS3ObjectLock(0, "bucket", "key", "", &svcS3)
+		capabilities, errProbe := l.ProbeS3Capabilities(context.Background())
+		assert.NoError(t, errProbe)
+		assert.Equal(t, expected, *capabilities)
+
+		l.ApplyS3Capabilities(capabilities)
+		if expected.ConditionalPut {
+			assert.Equal(t, S3AcquireConditionalPut, l.GetS3AcquireMode())
+		} else {
+			assert.Equal(t, S3AcquireCheckThenPut, l.GetS3AcquireMode())
+		}
+		if expected.ACL {
+			assert.Equal(t, DefaultS3ACL, l.GetS3ACL())
+		} else {
+			assert.Equal(t, types.ObjectCannedACL(""), l.GetS3ACL())
+		}
+	}
+
+	// The probe fails if the store rejects writes
+	svcS3 := mocks.MockS3Client{}
+	l := NewS3ObjectLock(0, "bucket", "key", "", &svcS3)
+	_, errProbe := l.ProbeS3Capabilities(context.Background())
+	assert.Error(t, errProbe)
+}
+
+func TestS3ObjectLockConditionalPut(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3,
+		WithS3AcquireMode(S3AcquireConditionalPut),
+		WithS3ACL(""),
+	)
+	assert.NoError(t, errNew)
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ObjectCannedACL(""), svcS3.PutObjectInput.ACL)
+	// The condition is sent along with disabling the SDK retries
+	assert.Len(t, svcS3.PutObjectOptFns, 2)
+
+	// Another session wrote the lock object after the lock state was checked
+	svcS3.PutObjectFunc = func(params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	errLock = l.Lock()
+	assert.Error(t, errLock)
+	assert.Contains(t, errLock.Error(), "is locked")
+
+	// Check then put sends no conditions
+	assert.NoError(t, l.SetS3AcquireMode(S3AcquireCheckThenPut))
+	svcS3.PutObjectFunc = nil
+	errLock = l.Lock()
+	assert.NoError(t, errLock)
+	assert.Len(t, svcS3.PutObjectOptFns, 1)
+}
+
+func TestS3ObjectLockCompatErrors(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "", &svcS3)
+	assert.Error(t, l.SetS3AcquireMode("unknown"))
+	assert.Equal(t, DefaultS3ACL, l.GetS3ACL())
+
+	_, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3ACL("unknown"))
+	assert.Error(t, errNew)
+	_, errNew = NewFileLockWithOptions(0, "file.txt", afero.NewMemMapFs(), WithS3ACL(DefaultS3ACL))
+	assert.Error(t, errNew)
+	_, errNew = NewFileLockWithOptions(0, "file.txt", afero.NewMemMapFs(), WithS3AcquireMode(S3AcquireConditionalPut))
+	assert.Error(t, errNew)
+
+	assert.False(t, isPreconditionFailed(nil))
+	assert.False(t, isPreconditionFailed(errors.New("error")))
+	assert.True(t, isPreconditionFailed(&smithy.GenericAPIError{Code: "ConditionalRequestConflict"}))
+}
@@ -0,0 +1,145 @@
+package safelock
+
+import (
+	"crypto/md5" // #nosec G501 S3 requires the MD5 digest of SSE-C keys
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3EncryptionMode is the server-side encryption used for S3 lock objects
+type S3EncryptionMode string
+
+const (
+	// S3EncryptionNone uses the bucket's default encryption
+	S3EncryptionNone S3EncryptionMode = "none"
+	// S3EncryptionSSES3 uses S3 managed keys
+	S3EncryptionSSES3 S3EncryptionMode = "sse-s3"
+	// S3EncryptionSSEKMS uses a KMS key, or the AWS managed key if no key id is given
+	S3EncryptionSSEKMS S3EncryptionMode = "sse-kms"
+	// S3EncryptionSSEC uses a key provided by the customer with every request
+	S3EncryptionSSEC S3EncryptionMode = "sse-c"
+)
+
+// S3CustomerKeyLength is the length of an SSE-C key in bytes
+const S3CustomerKeyLength = 32
+
+// sseCustomerAlgorithm is the only algorithm S3 supports for SSE-C
+const sseCustomerAlgorithm = "AES256"
+
+// S3Encryption configures the server-side encryption of S3 lock objects
+type S3Encryption struct {
+	// Mode is the kind of encryption, the bucket's default encryption is used if empty
+	Mode S3EncryptionMode
+	// KMSKeyID is the id or ARN of the KMS key for SSE-KMS
+	KMSKeyID string
+	// KMSEncryptionContext is the encryption context for SSE-KMS
+	KMSEncryptionContext map[string]string
+	// BucketKeyEnabled uses an S3 Bucket Key for SSE-KMS
+	BucketKeyEnabled bool
+	// CustomerKey is the 256-bit key for SSE-C
+	CustomerKey []byte
+}
+
+// Validate returns an error if the encryption configuration is not valid
+func (e S3Encryption) Validate() error {
+	switch e.Mode {
+	case "", S3EncryptionNone, S3EncryptionSSES3:
+	case S3EncryptionSSEKMS:
+		return nil
+	case S3EncryptionSSEC:
+		if len(e.CustomerKey) != S3CustomerKeyLength {
+			return fmt.Errorf("SSE-C key must be %d bytes, got %d", S3CustomerKeyLength, len(e.CustomerKey))
+		}
+		return nil
+	default:
+		return fmt.Errorf("s3 encryption mode %q is not valid", e.Mode)
+	}
+	if len(e.KMSKeyID) > 0 || len(e.KMSEncryptionContext) > 0 || e.BucketKeyEnabled {
+		return errors.New("KMS settings require SSE-KMS encryption")
+	}
+	if len(e.CustomerKey) > 0 {
+		return errors.New("a customer key requires SSE-C encryption")
+	}
+	return nil
+}
+
+// applyPutObject sets the encryption headers used when writing the lock object
+func (e S3Encryption) applyPutObject(input *s3.PutObjectInput) error {
+	switch e.Mode {
+	case S3EncryptionSSES3:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case S3EncryptionSSEKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		// without a key id S3 uses the AWS managed key
+		if len(e.KMSKeyID) > 0 {
+			input.SSEKMSKeyId = aws.String(e.KMSKeyID)
+		}
+		if len(e.KMSEncryptionContext) > 0 {
+			encryptionContext, errMarshal := json.Marshal(e.KMSEncryptionContext)
+			if errMarshal != nil {
+				return fmt.Errorf("unable to encode KMS encryption context: %w", errMarshal)
+			}
+			input.SSEKMSEncryptionContext = aws.String(base64.StdEncoding.EncodeToString(encryptionContext))
+		}
+		input.BucketKeyEnabled = e.BucketKeyEnabled
+	case S3EncryptionSSEC:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = e.customerKeyHeaders()
+	}
+	return nil
+}
+
+// applyGetObject sets the encryption headers used when reading the lock object
+// Only SSE-C needs headers to read an object, S3 rejects them for the other modes.
+func (e S3Encryption) applyGetObject(input *s3.GetObjectInput) {
+	if e.Mode == S3EncryptionSSEC {
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = e.customerKeyHeaders()
+	}
+}
+
+// applyHeadObject sets the encryption headers used when checking the lock object
+// Only SSE-C needs headers to read an object, S3 rejects them for the other modes.
+func (e S3Encryption) applyHeadObject(input *s3.HeadObjectInput) {
+	if e.Mode == S3EncryptionSSEC {
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = e.customerKeyHeaders()
+	}
+}
+
+// customerKeyHeaders returns the algorithm, encoded key and encoded key digest for SSE-C
+func (e S3Encryption) customerKeyHeaders() (*string, *string, *string) {
+	// #nosec G401 S3 requires the MD5 digest of SSE-C keys
+	digest := md5.Sum(e.CustomerKey)
+	return aws.String(sseCustomerAlgorithm),
+		aws.String(base64.StdEncoding.EncodeToString(e.CustomerKey)),
+		aws.String(base64.StdEncoding.EncodeToString(digest[:]))
+}
+
+// GetS3Encryption returns the server-side encryption used for the lock object
+func (l *S3ObjectLock) GetS3Encryption() S3Encryption {
+	return l.s3Encryption
+}
+
+// SetS3Encryption sets the server-side encryption used for the lock object
+func (l *S3ObjectLock) SetS3Encryption(encryption S3Encryption) error {
+	if errValidate := encryption.Validate(); errValidate != nil {
+		return errValidate
+	}
+	l.s3Encryption = encryption
+	return nil
+}
+
+// WithS3Encryption sets the server-side encryption used for S3 lock objects
+func WithS3Encryption(encryption S3Encryption) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 encryption is not supported by %T", l)
+		}
+		return s3l.SetS3Encryption(encryption)
+	}
+}
@@ -0,0 +1,138 @@
+package safelock
+
+import (
+	"bytes"
+	"crypto/md5" // #nosec G501 S3 requires the MD5 digest of SSE-C keys
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3EncryptionValidate(t *testing.T) {
+	customerKey := bytes.Repeat([]byte{1}, S3CustomerKeyLength)
+
+	valid := []S3Encryption{
+		{},
+		{Mode: S3EncryptionNone},
+		{Mode: S3EncryptionSSES3},
+		{Mode: S3EncryptionSSEKMS},
+		{Mode: S3EncryptionSSEKMS, KMSKeyID: "kmsKeyArn", KMSEncryptionContext: map[string]string{"a": "b"}, BucketKeyEnabled: true},
+		{Mode: S3EncryptionSSEC, CustomerKey: customerKey},
+	}
+	for _, e := range valid {
+		assert.NoError(t, e.Validate(), e.Mode)
+	}
+
+	invalid := []S3Encryption{
+		{Mode: "unknown"},
+		{Mode: S3EncryptionNone, KMSKeyID: "kmsKeyArn"},
+		{Mode: S3EncryptionSSES3, BucketKeyEnabled: true},
+		{Mode: S3EncryptionSSES3, CustomerKey: customerKey},
+		{Mode: S3EncryptionSSEC},
+		{Mode: S3EncryptionSSEC, CustomerKey: customerKey[1:]},
+	}
+	for _, e := range invalid {
+		assert.Error(t, e.Validate(), e.Mode)
+	}
+}
+
+func TestS3ObjectLockEncryptionSSES3(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3Encryption(S3Encryption{Mode: S3EncryptionSSES3}))
+	assert.NoError(t, errNew)
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ServerSideEncryptionAes256, svcS3.PutObjectInput.ServerSideEncryption)
+	assert.Nil(t, svcS3.PutObjectInput.SSEKMSKeyId)
+	assert.Nil(t, svcS3.PutObjectInput.SSECustomerKey)
+}
+
+func TestS3ObjectLockEncryptionSSEKMS(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3Encryption(S3Encryption{
+		Mode:                 S3EncryptionSSEKMS,
+		KMSKeyID:             "kmsKeyArn",
+		KMSEncryptionContext: map[string]string{"purpose": "lock"},
+		BucketKeyEnabled:     true,
+	}))
+	assert.NoError(t, errNew)
+	assert.Equal(t, "kmsKeyArn", l.GetS3KMSKeyArn())
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ServerSideEncryptionAwsKms, svcS3.PutObjectInput.ServerSideEncryption)
+	assert.Equal(t, "kmsKeyArn", *svcS3.PutObjectInput.SSEKMSKeyId)
+	assert.True(t, svcS3.PutObjectInput.BucketKeyEnabled)
+
+	encryptionContext, errDecode := base64.StdEncoding.DecodeString(*svcS3.PutObjectInput.SSEKMSEncryptionContext)
+	assert.NoError(t, errDecode)
+	assert.JSONEq(t, `{"purpose":"lock"}`, string(encryptionContext))
+}
+
+func TestS3ObjectLockEncryptionSSEC(t *testing.T) {
+
+	customerKey := bytes.Repeat([]byte{1}, S3CustomerKeyLength)
+	digest := md5.Sum(customerKey) // #nosec G401
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:  &s3.PutObjectOutput{},
+		HeadObjectOutput: &s3.HeadObjectOutput{},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3Encryption(S3Encryption{
+		Mode:        S3EncryptionSSEC,
+		CustomerKey: customerKey,
+	}))
+	assert.NoError(t, errNew)
+
+	errLock := l.Lock()
+	assert.Error(t, errLock)
+
+	// The customer key is sent when checking the lock state
+	assert.Equal(t, "AES256", *svcS3.HeadObjectInput.SSECustomerAlgorithm)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(customerKey), *svcS3.HeadObjectInput.SSECustomerKey)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), *svcS3.HeadObjectInput.SSECustomerKeyMD5)
+
+	// The customer key is sent when reading the lock
+	assert.Equal(t, base64.StdEncoding.EncodeToString(customerKey), *svcS3.GetObjectInput.SSECustomerKey)
+
+	// The customer key is sent when writing the lock
+	svcS3.HeadObjectOutput = nil
+	errLock = l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ServerSideEncryption(""), svcS3.PutObjectInput.ServerSideEncryption)
+	assert.Equal(t, "AES256", *svcS3.PutObjectInput.SSECustomerAlgorithm)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(customerKey), *svcS3.PutObjectInput.SSECustomerKey)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), *svcS3.PutObjectInput.SSECustomerKeyMD5)
+	_, errReadAll := ioutil.ReadAll(svcS3.PutObjectInput.Body)
+	assert.NoError(t, errReadAll)
+}
+
+func TestS3ObjectLockEncryptionErrors(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+	errSet := l.SetS3Encryption(S3Encryption{Mode: S3EncryptionSSEC})
+	assert.Error(t, errSet)
+	assert.Equal(t, S3Encryption{Mode: S3EncryptionSSEKMS, KMSKeyID: "kmsKeyArn"}, l.GetS3Encryption())
+
+	_, errNew := NewFileLockWithOptions(0, "file.txt", afero.NewMemMapFs(), WithS3Encryption(S3Encryption{}))
+	assert.Error(t, errNew)
+}
@@ -0,0 +1,180 @@
+package safelock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3HoldMode is how S3ObjectLock uses S3 Object Lock to protect the locked object while it is locked
+type S3HoldMode string
+
+const (
+	// S3HoldNone does not hold the locked object, only cooperating clients respect the lock
+	S3HoldNone S3HoldMode = "none"
+	// S3HoldLegalHold places a legal hold on the locked object until it is unlocked
+	S3HoldLegalHold S3HoldMode = "legal-hold"
+	// S3HoldGovernanceRetention places a governance mode retention on the locked object until
+	// it is unlocked or the lock's timeout elapses. Removing the retention when unlocking
+	// requires the s3:BypassGovernanceRetention permission.
+	S3HoldGovernanceRetention S3HoldMode = "governance-retention"
+)
+
+// GetS3HoldMode returns how the locked object is held while it is locked
+func (l *S3ObjectLock) GetS3HoldMode() S3HoldMode {
+	return l.s3HoldMode
+}
+
+// SetS3HoldMode sets how the locked object is held while it is locked
+// Holding the locked object requires the bucket to have S3 Object Lock enabled and the
+// s3 client to implement LockS3ObjectLockClient. S3 then rejects deleting the current
+// version of the locked object by any client, while the lock object still records the holder.
+// The version that was current when the lock was acquired is held and recorded in the lock object, so
+// that version is released even if a new version of the object was written while it was locked, including
+// by a session that takes over or unlocks the expired lock.
+func (l *S3ObjectLock) SetS3HoldMode(mode S3HoldMode) error {
+	switch mode {
+	case S3HoldNone:
+	case S3HoldLegalHold, S3HoldGovernanceRetention:
+		if _, ok := l.svcS3.(LockS3ObjectLockClient); !ok {
+			return fmt.Errorf("s3 hold mode %q requires the s3 client to support S3 Object Lock", mode)
+		}
+	default:
+		return fmt.Errorf("s3 hold mode %q is not valid", mode)
+	}
+	l.s3HoldMode = mode
+	return nil
+}
+
+// WithS3HoldMode sets how the locked S3 object is held while it is locked
+func WithS3HoldMode(mode S3HoldMode) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 hold modes are not supported by %T", l)
+		}
+		return s3l.SetS3HoldMode(mode)
+	}
+}
+
+// holdsObject returns true if a hold is placed on the locked object while it is locked
+func (l *S3ObjectLock) holdsObject() bool {
+	_, ok := l.svcS3.(LockS3ObjectLockClient)
+	return ok && l.s3HoldMode != S3HoldNone
+}
+
+// objectVersion returns the current version of the locked object, the mutex must be held
+func (l *S3ObjectLock) objectVersion(ctx context.Context) (string, error) {
+	headObjectOutput, errHeadObject := l.svcS3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(l.GetS3Bucket()),
+		Key:    aws.String(l.GetS3Key()),
+	})
+	if errHeadObject != nil {
+		return "", fmt.Errorf("unable to read the version of %s: %w", l.GetObjectURI(), errHeadObject)
+	}
+	return aws.ToString(headObjectOutput.VersionId), nil
+}
+
+// holdObject places the hold on the version of the locked object recorded when locking, the mutex must be held
+func (l *S3ObjectLock) holdObject(ctx context.Context) error {
+	svc, ok := l.svcS3.(LockS3ObjectLockClient)
+	if !ok {
+		return nil
+	}
+
+	switch l.s3HoldMode {
+	case S3HoldLegalHold:
+		_, errPutLegalHold := svc.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(l.GetS3Bucket()),
+			Key:       aws.String(l.GetS3Key()),
+			VersionId: optionalVersionID(l.s3HeldVersionID),
+			LegalHold: &types.ObjectLockLegalHold{
+				Status: types.ObjectLockLegalHoldStatusOn,
+			},
+		})
+		if errPutLegalHold != nil {
+			return fmt.Errorf("unable to place legal hold on %s: %w", l.GetObjectURI(), errPutLegalHold)
+		}
+	case S3HoldGovernanceRetention:
+		// retention can't be indefinite, a lock that never expires should use a legal hold
+		if l.GetTimeout() <= 0 {
+			return fmt.Errorf("s3 hold mode %q requires a timeout", l.s3HoldMode)
+		}
+		_, errPutRetention := svc.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+			Bucket:    aws.String(l.GetS3Bucket()),
+			Key:       aws.String(l.GetS3Key()),
+			VersionId: optionalVersionID(l.s3HeldVersionID),
+			Retention: &types.ObjectLockRetention{
+				Mode:            types.ObjectLockRetentionModeGovernance,
+				RetainUntilDate: aws.Time(l.GetClock().Now().Add(l.GetTimeout())),
+			},
+		})
+		if errPutRetention != nil {
+			return fmt.Errorf("unable to place retention on %s: %w", l.GetObjectURI(), errPutRetention)
+		}
+	}
+	return nil
+}
+
+// releaseObject removes the hold from the version of the locked object, or the current version if the
+// version is empty, the mutex must be held
+func (l *S3ObjectLock) releaseObject(ctx context.Context, versionID string) error {
+	svc, ok := l.svcS3.(LockS3ObjectLockClient)
+	if !ok {
+		return nil
+	}
+
+	switch l.s3HoldMode {
+	case S3HoldLegalHold:
+		_, errPutLegalHold := svc.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(l.GetS3Bucket()),
+			Key:       aws.String(l.GetS3Key()),
+			VersionId: optionalVersionID(versionID),
+			LegalHold: &types.ObjectLockLegalHold{
+				Status: types.ObjectLockLegalHoldStatusOff,
+			},
+		})
+		if errPutLegalHold != nil {
+			return fmt.Errorf("unable to remove legal hold from %s: %w", l.GetObjectURI(), errPutLegalHold)
+		}
+	case S3HoldGovernanceRetention:
+		_, errPutRetention := svc.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+			Bucket:                    aws.String(l.GetS3Bucket()),
+			Key:                       aws.String(l.GetS3Key()),
+			VersionId:                 optionalVersionID(versionID),
+			Retention:                 &types.ObjectLockRetention{},
+			BypassGovernanceRetention: true,
+		})
+		if errPutRetention != nil {
+			return fmt.Errorf("unable to remove retention from %s: %w", l.GetObjectURI(), errPutRetention)
+		}
+	}
+	if versionID == l.s3HeldVersionID {
+		l.s3HeldVersionID = ""
+	}
+	return nil
+}
+
+// releasedVersion returns the version of the locked object to release along with the lock, the mutex must be held
+// This is the version recorded in the lock, or the version held by this session if the lock does not record
+// it, otherwise it is empty to release the current version.
+func (l *S3ObjectLock) releasedVersion(held *lockBody) string {
+	if held != nil && len(held.heldVersionID) > 0 {
+		return held.heldVersionID
+	}
+	if held == nil || l.isOwnerSession(held) {
+		return l.s3HeldVersionID
+	}
+	return ""
+}
+
+// optionalVersionID returns the version id for a request, nil for the current version if it is empty
+func optionalVersionID(versionID string) *string {
+	if len(versionID) == 0 {
+		return nil
+	}
+	return aws.String(versionID)
+}
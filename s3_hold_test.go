@@ -0,0 +1,226 @@
+package safelock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLockS3Client is an s3 client with only the methods of LockS3Client
+type mockLockS3Client struct {
+	LockS3Client
+}
+
+// headVersion returns a HeadObject hook that reports the given version of the locked object and
+// the output at lockObject for the lock object, an error if it is nil
+func headVersion(versionID string, lockObject **s3.HeadObjectOutput) func(*s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return func(params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+		if aws.ToString(params.Key) == "key" {
+			return &s3.HeadObjectOutput{VersionId: aws.String(versionID)}, nil
+		}
+		if *lockObject == nil {
+			return nil, &types.NotFound{}
+		}
+		return *lockObject, nil
+	}
+}
+
+func TestS3ObjectLockLegalHold(t *testing.T) {
+
+	var lockObject *s3.HeadObjectOutput
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:          &s3.PutObjectOutput{},
+		PutObjectLegalHoldOutput: &s3.PutObjectLegalHoldOutput{},
+		DeleteObjectOutput:       &s3.DeleteObjectOutput{},
+		HeadObjectFunc:           headVersion("v1", &lockObject),
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3HoldMode(S3HoldLegalHold))
+	assert.NoError(t, errNew)
+	assert.Equal(t, S3HoldLegalHold, l.GetS3HoldMode())
+
+	// The legal hold is placed on the current version of the locked object
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, "bucket", *svcS3.PutObjectLegalHoldInput.Bucket)
+	assert.Equal(t, "key", *svcS3.PutObjectLegalHoldInput.Key)
+	assert.Equal(t, "v1", aws.ToString(svcS3.PutObjectLegalHoldInput.VersionId))
+	assert.Equal(t, types.ObjectLockLegalHoldStatusOn, svcS3.PutObjectLegalHoldInput.LegalHold.Status)
+	assert.Equal(t, "v1", svcS3.PutObjectInput.Metadata[s3MetadataHeld])
+
+	// The legal hold is removed from the held version when unlocking, even if a new version was written
+	svcS3.HeadObjectFunc = headVersion("v2", &lockObject)
+	lockObject = &s3.HeadObjectOutput{}
+	errForceUnlock := l.ForceUnlock()
+	assert.NoError(t, errForceUnlock)
+	assert.Equal(t, "v1", aws.ToString(svcS3.PutObjectLegalHoldInput.VersionId))
+	assert.Equal(t, types.ObjectLockLegalHoldStatusOff, svcS3.PutObjectLegalHoldInput.LegalHold.Status)
+
+	// The lock is not released if the legal hold can't be removed
+	svcS3.PutObjectLegalHoldOutput = nil
+	svcS3.DeleteObjectOutput = nil
+	errForceUnlock = l.ForceUnlock()
+	assert.Error(t, errForceUnlock)
+	assert.Contains(t, errForceUnlock.Error(), "legal hold")
+
+	// The lock object is removed if the legal hold can't be placed
+	lockObject = nil
+	svcS3.DeleteObjectOutput = &s3.DeleteObjectOutput{}
+	errLock = l.Lock()
+	assert.Error(t, errLock)
+	assert.Equal(t, "v2", aws.ToString(svcS3.PutObjectLegalHoldInput.VersionId))
+	assert.Equal(t, "key.lock", *svcS3.DeleteObjectInput.Key)
+
+	// The lock object is not written if the version of the locked object can't be read
+	svcS3.HeadObjectFunc = nil
+	svcS3.PutObjectInput = nil
+	errLock = l.Lock()
+	assert.Error(t, errLock)
+	assert.Contains(t, errLock.Error(), "version")
+	assert.Nil(t, svcS3.PutObjectInput)
+}
+
+func TestS3ObjectLockHoldTakeover(t *testing.T) {
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	// The expired lock of another session held the first version of the locked object
+	other := NewS3ObjectLock(1, "bucket", "key", "", &mocks.MockS3Client{})
+	other.SetClock(c)
+	otherBody, errParse := parseLockBody(other.GetLockBody())
+	assert.NoError(t, errParse)
+	otherBody.heldVersionID = "v1"
+	lockObject := &s3.HeadObjectOutput{
+		Metadata:     lockMetadata(otherBody, ""),
+		LastModified: aws.Time(c.Now()),
+	}
+	c.Advance(time.Hour)
+
+	holds := []string{}
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:    &s3.PutObjectOutput{},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+		HeadObjectFunc:     headVersion("v2", &lockObject),
+		PutObjectLegalHoldFunc: func(params *s3.PutObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+			holds = append(holds, string(params.LegalHold.Status)+" "+aws.ToString(params.VersionId))
+			return &s3.PutObjectLegalHoldOutput{}, nil
+		},
+	}
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3HoldMode(S3HoldLegalHold), WithClock(c))
+	assert.NoError(t, errNew)
+
+	// The version held by the other session is released when its lock is taken over
+	assert.NoError(t, l.Lock())
+	assert.Equal(t, []string{"OFF v1", "ON v2"}, holds)
+
+	// The version held by the other session is released when its expired lock is unlocked
+	holds = nil
+	assert.NoError(t, l.Unlock())
+	assert.Equal(t, []string{"OFF v1"}, holds)
+}
+
+func TestS3ObjectLockGovernanceRetention(t *testing.T) {
+
+	var lockObject *s3.HeadObjectOutput
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:          &s3.PutObjectOutput{},
+		PutObjectRetentionOutput: &s3.PutObjectRetentionOutput{},
+		DeleteObjectOutput:       &s3.DeleteObjectOutput{},
+		HeadObjectFunc:           headVersion("v1", &lockObject),
+	}
+
+	clock := NewFakeClock(time.Unix(1000, 0))
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3,
+		WithS3HoldMode(S3HoldGovernanceRetention),
+		WithClock(clock),
+		WithTimeout(time.Minute),
+	)
+	assert.NoError(t, errNew)
+
+	// The retention lasts until the lock expires
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, types.ObjectLockRetentionModeGovernance, svcS3.PutObjectRetentionInput.Retention.Mode)
+	assert.True(t, svcS3.PutObjectRetentionInput.Retention.RetainUntilDate.Equal(time.Unix(1060, 0)))
+	assert.Equal(t, "v1", aws.ToString(svcS3.PutObjectRetentionInput.VersionId))
+
+	// The retention is removed from the held version when unlocking
+	lockObject = &s3.HeadObjectOutput{}
+	errForceUnlock := l.ForceUnlock()
+	assert.NoError(t, errForceUnlock)
+	assert.True(t, svcS3.PutObjectRetentionInput.BypassGovernanceRetention)
+	assert.Equal(t, "v1", aws.ToString(svcS3.PutObjectRetentionInput.VersionId))
+	assert.Equal(t, types.ObjectLockRetentionMode(""), svcS3.PutObjectRetentionInput.Retention.Mode)
+
+	// Retention requires a timeout
+	lockObject = nil
+	l.SetTimeout(0)
+	errLock = l.Lock()
+	assert.Error(t, errLock)
+}
+
+// mutexBackoff records whether the mutex of the lock was free whenever a retry waits
+type mutexBackoff struct {
+	l    *S3ObjectLock
+	free []bool
+}
+
+func (b *mutexBackoff) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	free := b.l.mu.TryLock()
+	if free {
+		b.l.mu.Unlock()
+	}
+	b.free = append(b.free, free)
+	return 0, attempt < 3
+}
+
+func TestS3ObjectLockHoldRetry(t *testing.T) {
+
+	var lockObject *s3.HeadObjectOutput
+	attempts := 0
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:    &s3.PutObjectOutput{},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+		HeadObjectFunc:     headVersion("v1", &lockObject),
+		PutObjectLegalHoldFunc: func(params *s3.PutObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, &smithy.GenericAPIError{Code: "SlowDown"}
+			}
+			return &s3.PutObjectLegalHoldOutput{}, nil
+		},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3HoldMode(S3HoldLegalHold))
+	assert.NoError(t, errNew)
+	backoff := &mutexBackoff{l: l}
+	l.SetRetryBackoff(backoff)
+
+	// The hold is retried without holding the mutex
+	assert.NoError(t, l.Lock())
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []bool{true}, backoff.free)
+	assert.Equal(t, "v1", aws.ToString(svcS3.PutObjectLegalHoldInput.VersionId))
+}
+
+func TestS3ObjectLockHoldModeErrors(t *testing.T) {
+
+	l := NewS3ObjectLock(0, "bucket", "key", "", &mocks.MockS3Client{})
+	assert.Equal(t, S3HoldNone, l.GetS3HoldMode())
+	assert.Error(t, l.SetS3HoldMode("unknown"))
+
+	// The client must support S3 Object Lock
+	l = NewS3ObjectLock(0, "bucket", "key", "", mockLockS3Client{})
+	assert.Error(t, l.SetS3HoldMode(S3HoldLegalHold))
+	assert.NoError(t, l.SetS3HoldMode(S3HoldNone))
+
+	_, errNew := NewFileLockWithOptions(0, "file.txt", afero.NewMemMapFs(), WithS3HoldMode(S3HoldLegalHold))
+	assert.Error(t, errNew)
+}
@@ -0,0 +1,232 @@
+package safelock
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// s3MetadataPrefix is the prefix of the metadata keys and tags written by safelock
+	s3MetadataPrefix = "safelock-"
+
+	s3MetadataNode      = s3MetadataPrefix + "node"
+	s3MetadataNodeName  = s3MetadataPrefix + "node-name"
+	s3MetadataID        = s3MetadataPrefix + "id"
+	s3MetadataHostname  = s3MetadataPrefix + "hostname"
+	s3MetadataAcquired  = s3MetadataPrefix + "acquired"
+	s3MetadataExpiresAt = s3MetadataPrefix + "expires-at"
+	s3MetadataHeld      = s3MetadataPrefix + "held-version"
+
+	// s3MetadataNever is the expires-at value of a lock that never expires
+	s3MetadataNever = "never"
+
+	// MaxS3Tags is the maximum number of tags S3 allows on an object
+	MaxS3Tags = 10
+
+	// maxS3TagKeyLength and maxS3TagValueLength are the longest tag keys and values S3 allows
+	maxS3TagKeyLength   = 128
+	maxS3TagValueLength = 256
+
+	// maxS3MetadataSize is the most user-defined metadata S3 allows, in bytes of the keys and values
+	maxS3MetadataSize = 2048
+)
+
+// s3HolderTags are the tags written by safelock on lock objects if holder tags are enabled
+var s3HolderTags = []string{s3MetadataNode, s3MetadataID, s3MetadataHostname, s3MetadataExpiresAt}
+
+// GetS3HolderTags returns whether the holder is recorded in the tags of the lock object
+func (l *S3ObjectLock) GetS3HolderTags() bool {
+	return l.s3HolderTags
+}
+
+// SetS3HolderTags sets whether the holder is recorded in the tags of the lock object, in addition
+// to its metadata. Tagging requires the s3:PutObjectTagging permission and a store that supports
+// tags, so it is disabled by default. Returns an error if there are too many tags to add the holder's.
+func (l *S3ObjectLock) SetS3HolderTags(holderTags bool) error {
+	if holderTags && len(l.s3Tags)+len(s3HolderTags) > MaxS3Tags {
+		return fmt.Errorf("at most %d tags may be set with the holder's tags", MaxS3Tags-len(s3HolderTags))
+	}
+	l.s3HolderTags = holderTags
+	return nil
+}
+
+// WithS3HolderTags sets whether the holder is recorded in the tags of S3 lock objects
+func WithS3HolderTags(holderTags bool) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 holder tags are not supported by %T", l)
+		}
+		return s3l.SetS3HolderTags(holderTags)
+	}
+}
+
+// GetS3Tags returns the tags added to the lock object in addition to the holder's tags
+func (l *S3ObjectLock) GetS3Tags() map[string]string {
+	tags := make(map[string]string, len(l.s3Tags))
+	for k, v := range l.s3Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// SetS3Tags sets the tags added to the lock object in addition to the holder's tags
+// The tags can be used by bucket lifecycle rules, for example to remove abandoned locks.
+// Tag keys must not start with "safelock-" and at most MaxS3Tags tags may be set
+// including the holder's tags, if enabled.
+func (l *S3ObjectLock) SetS3Tags(tags map[string]string) error {
+	maxTags := MaxS3Tags
+	if l.s3HolderTags {
+		maxTags -= len(s3HolderTags)
+	}
+	if len(tags) > maxTags {
+		return fmt.Errorf("at most %d tags may be set", maxTags)
+	}
+	s3Tags := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if len(k) == 0 {
+			return errors.New("tag keys must not be empty")
+		}
+		if strings.HasPrefix(k, s3MetadataPrefix) {
+			return fmt.Errorf("tag key %q must not start with %q", k, s3MetadataPrefix)
+		}
+		if len(k) > maxS3TagKeyLength {
+			return fmt.Errorf("tag key %q is longer than %d characters", k, maxS3TagKeyLength)
+		}
+		if len(v) > maxS3TagValueLength {
+			return fmt.Errorf("tag value for %q is longer than %d characters", k, maxS3TagValueLength)
+		}
+		s3Tags[k] = v
+	}
+	l.s3Tags = s3Tags
+	return nil
+}
+
+// WithS3Tags sets the tags added to S3 lock objects in addition to the holder's tags
+func WithS3Tags(tags map[string]string) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 tags are not supported by %T", l)
+		}
+		return s3l.SetS3Tags(tags)
+	}
+}
+
+// lockMetadata returns the metadata describing the holder of the lock body
+// Values that may contain characters S3 does not allow in headers are escaped. A holder that does not
+// fit in the metadata S3 allows, because of a long node name, is only recorded in the lock body.
+func lockMetadata(body *lockBody, hostname string) map[string]string {
+	metadata := map[string]string{
+		s3MetadataNode:      strconv.FormatUint(uint64(binary.LittleEndian.Uint16(body.node)), 10),
+		s3MetadataID:        body.id.String(),
+		s3MetadataAcquired:  body.timestamp.UTC().Format(time.RFC3339Nano),
+		s3MetadataExpiresAt: s3MetadataNever,
+	}
+	if !body.expiresAt.IsZero() {
+		metadata[s3MetadataExpiresAt] = body.expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	if len(body.nodeName) > 0 {
+		metadata[s3MetadataNodeName] = url.PathEscape(body.nodeName)
+	}
+	if len(hostname) > 0 {
+		metadata[s3MetadataHostname] = url.PathEscape(hostname)
+	}
+	if metadataSize(metadata) > maxS3MetadataSize-len(s3MetadataHeld)-len(body.heldVersionID) {
+		metadata = map[string]string{}
+	}
+	if len(body.heldVersionID) > 0 {
+		metadata[s3MetadataHeld] = body.heldVersionID
+	}
+	return metadata
+}
+
+// metadataSize returns the size of the metadata as counted by S3
+func metadataSize(metadata map[string]string) int {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// lockTagging returns the URL encoded tags for the lock object from the holder's metadata and the user's tags
+// The holder is not recorded if metadata is nil.
+func lockTagging(metadata map[string]string, tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	for _, k := range s3HolderTags {
+		if v, ok := metadata[k]; ok {
+			values.Set(k, v)
+		}
+	}
+	return values.Encode()
+}
+
+// parseLockMetadata parses the lock body from the metadata of a lock object
+// Returns false if the lock object was not written with metadata.
+func parseLockMetadata(metadata map[string]string) (*lockBody, bool) {
+	// S3 returns metadata keys in lower case, but other stores may not
+	m := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		m[strings.ToLower(k)] = v
+	}
+
+	node, errNode := strconv.ParseUint(m[s3MetadataNode], 10, 16)
+	if errNode != nil {
+		return nil, false
+	}
+	id, errID := uuid.Parse(m[s3MetadataID])
+	if errID != nil {
+		return nil, false
+	}
+	acquired, errAcquired := time.Parse(time.RFC3339Nano, m[s3MetadataAcquired])
+	if errAcquired != nil {
+		return nil, false
+	}
+
+	body := &lockBody{
+		node:         make([]byte, 2),
+		id:           id,
+		timestamp:    acquired,
+		hasExpiresAt: true,
+	}
+	binary.LittleEndian.PutUint16(body.node, uint16(node))
+
+	if expiresAt := m[s3MetadataExpiresAt]; expiresAt != s3MetadataNever {
+		t, errExpiresAt := time.Parse(time.RFC3339Nano, expiresAt)
+		if errExpiresAt != nil {
+			return nil, false
+		}
+		body.expiresAt = t
+	}
+
+	if nodeName, errUnescape := url.PathUnescape(m[s3MetadataNodeName]); errUnescape == nil {
+		body.nodeName = nodeName
+	}
+	if hostname, errUnescape := url.PathUnescape(m[s3MetadataHostname]); errUnescape == nil {
+		body.hostname = hostname
+	}
+	body.heldVersionID = m[s3MetadataHeld]
+
+	return body, true
+}
+
+// lockHostname returns the hostname recorded as the holder of the lock, or empty if unknown
+func lockHostname() string {
+	hostname, errHostname := os.Hostname()
+	if errHostname != nil {
+		return ""
+	}
+	return hostname
+}
@@ -0,0 +1,188 @@
+package safelock
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3ObjectLockMetadata(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	clock := NewFakeClock(time.Unix(1000, 0))
+	l, errNew := NewS3ObjectLockWithOptions(1, "bucket", "key", &svcS3,
+		WithClock(clock),
+		WithTimeout(time.Minute),
+		WithNodeName("node/a"),
+		WithS3Tags(map[string]string{"lifecycle": "lock"}),
+	)
+	assert.NoError(t, errNew)
+
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+
+	// The holder is recorded in the metadata
+	metadata := svcS3.PutObjectInput.Metadata
+	assert.Equal(t, "1", metadata["safelock-node"])
+	assert.Equal(t, "node%2Fa", metadata["safelock-node-name"])
+	assert.Equal(t, l.GetSessionID().String(), metadata["safelock-id"])
+	assert.Equal(t, "1970-01-01T00:16:40Z", metadata["safelock-acquired"])
+	assert.Equal(t, "1970-01-01T00:17:40Z", metadata["safelock-expires-at"])
+	assert.Equal(t, url.PathEscape(lockHostname()), metadata["safelock-hostname"])
+
+	// Only the user's tags are recorded in the tags by default
+	tags, errParseQuery := url.ParseQuery(*svcS3.PutObjectInput.Tagging)
+	assert.NoError(t, errParseQuery)
+	assert.Equal(t, "lock", tags.Get("lifecycle"))
+	assert.False(t, tags.Has("safelock-node"))
+
+	// The holder is recorded in the tags once enabled
+	assert.False(t, l.GetS3HolderTags())
+	assert.NoError(t, WithS3HolderTags(true)(l))
+	assert.True(t, l.GetS3HolderTags())
+	assert.NoError(t, l.Lock())
+	tags, errParseQuery = url.ParseQuery(*svcS3.PutObjectInput.Tagging)
+	assert.NoError(t, errParseQuery)
+	assert.Equal(t, "lock", tags.Get("lifecycle"))
+	assert.Equal(t, "1", tags.Get("safelock-node"))
+	assert.Equal(t, l.GetSessionID().String(), tags.Get("safelock-id"))
+	assert.Equal(t, "1970-01-01T00:17:40Z", tags.Get("safelock-expires-at"))
+	assert.False(t, tags.Has("safelock-acquired"))
+
+	// The owner is read from the metadata without reading the body
+	svcS3.HeadObjectOutput = &s3.HeadObjectOutput{
+		Metadata: metadata,
+	}
+	owner, errGetOwner := l.GetOwner()
+	assert.NoError(t, errGetOwner)
+	assert.Nil(t, svcS3.GetObjectInput)
+	assert.Equal(t, uint16(1), owner.Node)
+	assert.Equal(t, "node/a", owner.NodeName)
+	assert.Equal(t, l.GetSessionID(), owner.SessionID)
+	assert.Equal(t, lockHostname(), owner.Hostname)
+	assert.True(t, owner.Acquired.Equal(time.Unix(1000, 0)))
+	assert.True(t, owner.ExpiresAt.Equal(time.Unix(1060, 0)))
+	assert.False(t, owner.Expired)
+
+	held, errIsHeld := l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.True(t, held)
+
+	// The lock expires according to the metadata
+	clock.Advance(2 * time.Minute)
+	owner, errGetOwner = l.GetOwner()
+	assert.NoError(t, errGetOwner)
+	assert.True(t, owner.Expired)
+}
+
+func TestS3ObjectLockMetadataNodeNameTooLong(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput: &s3.PutObjectOutput{},
+	}
+
+	// The escaped node name does not fit in the metadata S3 allows
+	nodeName := strings.Repeat("é", MaxNodeNameLength/2)
+	l, errNew := NewS3ObjectLockWithOptions(1, "bucket", "key", &svcS3, WithNodeName(nodeName))
+	assert.NoError(t, errNew)
+
+	assert.NoError(t, l.Lock())
+	assert.Empty(t, svcS3.PutObjectInput.Metadata)
+
+	// The owner is read from the body
+	svcS3.HeadObjectOutput = &s3.HeadObjectOutput{
+		Metadata: svcS3.PutObjectInput.Metadata,
+	}
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewReader(l.GetLockBody())),
+	}
+	owner, errGetOwner := l.GetOwner()
+	assert.NoError(t, errGetOwner)
+	assert.Equal(t, nodeName, owner.NodeName)
+
+	// The version of a held object is still recorded
+	body, errParse := parseLockBody(l.GetLockBody())
+	assert.NoError(t, errParse)
+	body.heldVersionID = "v1"
+	metadata := lockMetadata(body, "host")
+	assert.Equal(t, map[string]string{s3MetadataHeld: "v1"}, metadata)
+	assert.LessOrEqual(t, metadataSize(lockMetadata(body, strings.Repeat("h", 253))), maxS3MetadataSize)
+}
+
+func TestParseLockMetadata(t *testing.T) {
+
+	metadata := map[string]string{
+		"Safelock-Node":       "1",
+		"Safelock-Id":         "0188b6d6-8c2d-7000-8000-000000000000",
+		"Safelock-Acquired":   "1970-01-01T00:16:40Z",
+		"Safelock-Expires-At": "never",
+	}
+	body, ok := parseLockMetadata(metadata)
+	assert.True(t, ok)
+	ttl, ok := body.ttl()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	// Lock objects written without metadata fall back to the body
+	for _, k := range []string{"Safelock-Node", "Safelock-Id", "Safelock-Acquired", "Safelock-Expires-At"} {
+		invalid := map[string]string{}
+		for mk, mv := range metadata {
+			invalid[mk] = mv
+		}
+		invalid[k] = "invalid"
+		_, ok := parseLockMetadata(invalid)
+		assert.False(t, ok, k)
+	}
+	_, ok = parseLockMetadata(nil)
+	assert.False(t, ok)
+}
+
+func TestS3ObjectLockTagsErrors(t *testing.T) {
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &mocks.MockS3Client{})
+
+	tooMany := map[string]string{}
+	for i := 0; i <= MaxS3Tags; i++ {
+		tooMany[fmt.Sprintf("tag%d", i)] = "value"
+	}
+
+	tests := []map[string]string{
+		tooMany,
+		{"": "value"},
+		{"safelock-node": "1"},
+		{strings.Repeat("k", 129): "value"},
+		{"key": strings.Repeat("v", 257)},
+	}
+	for _, tags := range tests {
+		assert.Error(t, l.SetS3Tags(tags))
+	}
+
+	assert.NoError(t, l.SetS3Tags(map[string]string{"lifecycle": "lock"}))
+	assert.Equal(t, map[string]string{"lifecycle": "lock"}, l.GetS3Tags())
+
+	// The holder's tags count towards the maximum
+	delete(tooMany, "tag0")
+	assert.NoError(t, l.SetS3Tags(tooMany))
+	assert.Error(t, l.SetS3HolderTags(true))
+	assert.NoError(t, l.SetS3Tags(map[string]string{"lifecycle": "lock"}))
+	assert.NoError(t, l.SetS3HolderTags(true))
+	assert.Error(t, l.SetS3Tags(tooMany))
+
+	// Without tags the lock object is written without tagging
+	svcS3 := mocks.MockS3Client{PutObjectOutput: &s3.PutObjectOutput{}}
+	untagged := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+	assert.NoError(t, untagged.Lock())
+	assert.Nil(t, svcS3.PutObjectInput.Tagging)
+}
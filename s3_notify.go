@@ -0,0 +1,170 @@
+package safelock
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	// DefaultNotificationBackoffInterval is the default interval between checks of the lock state
+	// when waiting on S3 event notifications
+	DefaultNotificationBackoffInterval time.Duration = 1 * time.Minute
+
+	// DefaultNotificationBackoffJitter is the default maximum jitter added to the notification backoff interval
+	DefaultNotificationBackoffJitter time.Duration = 5 * time.Second
+
+	// notificationWaitTimeSeconds is the SQS long polling duration
+	notificationWaitTimeSeconds = 20
+
+	// notificationMaxMessages is the maximum number of messages received from SQS at a time
+	notificationMaxMessages = 10
+)
+
+// s3EventNotification is the subset of an S3 event notification used to detect lock removal
+type s3EventNotification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// snsNotification is the envelope used when S3 event notifications are delivered through SNS
+type snsNotification struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// SetNotificationQueue configures WaitForLock to wake on ObjectRemoved events for the lock object
+// delivered by S3 event notifications to the SQS queue at queueURL. Only the messages about the lock
+// object are deleted, messages about other objects are made visible again at once for the waiters of
+// other locks. A message is received by a single waiter, so waiters of the same lock should each use
+// their own queue, e.g. subscribed to an SNS topic, otherwise the other waiters only notice the removal
+// at their next check of the lock state. A nil client disables notifications.
+func (l *S3ObjectLock) SetNotificationQueue(svcSQS LockSQSClient, queueURL string) {
+	l.svcSQS = svcSQS
+	l.sqsQueueURL = queueURL
+}
+
+// GetNotificationQueueURL returns the SQS queue URL used for S3 event notifications
+func (l *S3ObjectLock) GetNotificationQueueURL() string {
+	return l.sqsQueueURL
+}
+
+// GetNotificationBackoff returns the backoff strategy used to poll the lock state while waiting on notifications
+func (l *S3ObjectLock) GetNotificationBackoff() Backoff {
+	if l.notificationBackoff == nil {
+		return NewConstantBackoff(DefaultNotificationBackoffInterval, DefaultNotificationBackoffJitter)
+	}
+	return l.notificationBackoff
+}
+
+// SetNotificationBackoff sets the backoff strategy used to poll the lock state while waiting on notifications
+// A nil backoff restores the default strategy.
+func (l *S3ObjectLock) SetNotificationBackoff(backoff Backoff) {
+	l.notificationBackoff = backoff
+}
+
+// receiveNotifications long polls the notification queue until the context is cancelled and
+// signals on the returned channel whenever the lock object is removed
+func (l *S3ObjectLock) receiveNotifications(ctx context.Context) <-chan struct{} {
+	// Buffer a single wakeup so that a removal between checks is not missed
+	wake := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			receiveMessageOutput, errReceiveMessage := l.svcSQS.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            aws.String(l.sqsQueueURL),
+				MaxNumberOfMessages: notificationMaxMessages,
+				WaitTimeSeconds:     notificationWaitTimeSeconds,
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if errReceiveMessage != nil {
+				// Polling continues as a fallback, so retry after a pause
+				select {
+				case <-ctx.Done():
+					return
+				case <-l.GetClock().After(time.Second):
+				}
+				continue
+			}
+
+			for _, message := range receiveMessageOutput.Messages {
+				eventNames := l.lockEventNames(aws.ToString(message.Body))
+				if len(eventNames) == 0 {
+					// Errors are ignored because the message is visible again once its visibility timeout ends
+					_, _ = l.svcSQS.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+						QueueUrl:          aws.String(l.sqsQueueURL),
+						ReceiptHandle:     message.ReceiptHandle,
+						VisibilityTimeout: 0,
+					})
+					continue
+				}
+				// Errors are ignored because the message will be delivered again
+				_, _ = l.svcSQS.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(l.sqsQueueURL),
+					ReceiptHandle: message.ReceiptHandle,
+				})
+				if isRemovedEvent(eventNames) {
+					select {
+					case wake <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return wake
+}
+
+// lockEventNames returns the names of the events about the lock object in the message body, an S3 event
+// notification optionally wrapped by SNS
+func (l *S3ObjectLock) lockEventNames(body string) []string {
+	sns := snsNotification{}
+	if errUnmarshal := json.Unmarshal([]byte(body), &sns); errUnmarshal == nil && sns.Type == "Notification" {
+		body = sns.Message
+	}
+
+	event := s3EventNotification{}
+	if errUnmarshal := json.Unmarshal([]byte(body), &event); errUnmarshal != nil {
+		return nil
+	}
+
+	eventNames := []string{}
+	for _, record := range event.Records {
+		// Object keys in event notifications are URL encoded
+		key, errUnescape := url.QueryUnescape(record.S3.Object.Key)
+		if errUnescape != nil {
+			continue
+		}
+		if record.S3.Bucket.Name == l.GetLockBucket() && key == l.GetLockPath() {
+			eventNames = append(eventNames, record.EventName)
+		}
+	}
+	return eventNames
+}
+
+// isRemovedEvent returns true if any of the event names reports the removal of an object
+func isRemovedEvent(eventNames []string) bool {
+	for _, eventName := range eventNames {
+		if strings.HasPrefix(eventName, "ObjectRemoved:") {
+			return true
+		}
+	}
+	return false
+}
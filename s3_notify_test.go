@@ -0,0 +1,99 @@
+package safelock
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3ObjectLockNotificationMessages(t *testing.T) {
+
+	l := NewS3ObjectLock(0, "bucket", "path/my key", "kmsKeyArn", &mocks.MockS3Client{})
+
+	removed := `{"Records":[{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"bucket"},"object":{"key":"path/my+key.lock"}}}]}`
+	assert.True(t, isRemovedEvent(l.lockEventNames(removed)))
+
+	// Delivered through SNS
+	wrapped := `{"Type":"Notification","Message":"{\"Records\":[{\"eventName\":\"ObjectRemoved:DeleteMarkerCreated\",\"s3\":{\"bucket\":{\"name\":\"bucket\"},\"object\":{\"key\":\"path/my+key.lock\"}}}]}"}`
+	assert.True(t, isRemovedEvent(l.lockEventNames(wrapped)))
+
+	// Lock creation is not a removal
+	created := `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"path/my+key.lock"}}}]}`
+	assert.False(t, isRemovedEvent(l.lockEventNames(created)))
+
+	// Other objects are ignored
+	other := `{"Records":[{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"bucket"},"object":{"key":"path/other.lock"}}}]}`
+	assert.False(t, isRemovedEvent(l.lockEventNames(other)))
+	otherBucket := `{"Records":[{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"other"},"object":{"key":"path/my+key.lock"}}}]}`
+	assert.False(t, isRemovedEvent(l.lockEventNames(otherBucket)))
+
+	// Test events and garbage are ignored
+	assert.False(t, isRemovedEvent(l.lockEventNames(`{"Service":"Amazon S3","Event":"s3:TestEvent"}`)))
+	assert.False(t, isRemovedEvent(l.lockEventNames(`not json`)))
+}
+
+func TestS3ObjectLockWaitNotification(t *testing.T) {
+
+	// Pretend that the lock is locked until it is removed
+	var removed int32
+	svcS3 := mocks.MockS3Client{
+		HeadObjectFunc: func(params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if atomic.LoadInt32(&removed) == 1 {
+				return nil, &s3types.NotFound{}
+			}
+			return &s3.HeadObjectOutput{}, nil
+		},
+	}
+	svcSQS := mocks.MockSQSClient{
+		Messages: make(chan types.Message),
+	}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+	l.SetNotificationQueue(&svcSQS, "https://sqs.us-west-2.amazonaws.com/123456789012/queue")
+	assert.Equal(t, "https://sqs.us-west-2.amazonaws.com/123456789012/queue", l.GetNotificationQueueURL())
+
+	// Poll rarely so that only the notification can end the wait quickly
+	l.SetNotificationBackoff(NewConstantBackoff(time.Minute, 0))
+
+	done := make(chan error)
+	go func() {
+		done <- l.WaitForLock(DefaultTimeout)
+	}()
+
+	// Wait long enough for the first check of the lock state
+	time.Sleep(100 * time.Millisecond)
+
+	// Notifications about other objects are made visible again for their waiters
+	svcSQS.Messages <- types.Message{
+		Body:          aws.String(`{"Records":[{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"bucket"},"object":{"key":"other.lock"}}}]}`),
+		ReceiptHandle: aws.String("other"),
+	}
+
+	// Remove the lock and notify the waiter
+	atomic.StoreInt32(&removed, 1)
+	svcSQS.Messages <- types.Message{
+		Body:          aws.String(`{"Records":[{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"bucket"},"object":{"key":"key.lock"}}}]}`),
+		ReceiptHandle: aws.String("receipt"),
+	}
+
+	select {
+	case errWaitForLock := <-done:
+		assert.NoError(t, errWaitForLock)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForLock did not return after the lock object was removed")
+	}
+	assert.Equal(t, []string{"receipt"}, svcSQS.Deleted())
+	assert.Equal(t, []string{"other"}, svcSQS.Released())
+
+	// Restore the default
+	l.SetNotificationBackoff(nil)
+	assert.IsType(t, &ConstantBackoff{}, l.GetNotificationBackoff())
+}
@@ -2,6 +2,7 @@ package safelock
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -12,7 +13,10 @@ import (
 
 	"github.com/deptofdefense/safelock/internal/mocks"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -52,8 +56,8 @@ func TestS3ObjectLock(t *testing.T) {
 	errUnlock := l.Unlock()
 	assert.NoError(t, errUnlock)
 
-	nodeCreation := time.Unix(0, int64(l.GetID()))
-	assert.True(t, time.Since(nodeCreation) < time.Second)
+	// The session id is a random version 7 UUID
+	assert.Equal(t, uuid.Version(7), l.GetSessionID().Version())
 
 	// Remove info about the lock
 	svcS3.HeadObjectOutput = nil
@@ -150,6 +154,49 @@ func TestS3ObjectLockUnlockErrors(t *testing.T) {
 	assert.Error(t, errUnlock)
 }
 
+func TestS3ObjectLockStateErrors(t *testing.T) {
+
+	headErrs := []error{&smithy.GenericAPIError{Code: "SlowDown"}}
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:    &s3.PutObjectOutput{},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+		HeadObjectFunc: func(params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if len(headErrs) > 0 {
+				errHead := headErrs[0]
+				headErrs = headErrs[1:]
+				return nil, errHead
+			}
+			return nil, &types.NotFound{}
+		},
+	}
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithRetryBackoff(noDelayRetry(3)))
+	assert.NoError(t, errNew)
+
+	// A throttled check is retried
+	lockState, errGetLockState := l.GetLockState()
+	assert.NoError(t, errGetLockState)
+	assert.Equal(t, LockStateUnlocked, lockState)
+
+	// Other errors leave the state unknown, so the lock is neither written nor removed
+	denied := &smithy.GenericAPIError{Code: "AccessDenied"}
+	headErrs = []error{denied, denied, denied, denied}
+	lockState, errGetLockState = l.GetLockState()
+	assert.ErrorIs(t, errGetLockState, denied)
+	assert.Equal(t, LockStateUnknown, lockState)
+
+	assert.ErrorIs(t, l.Lock(), denied)
+	assert.Nil(t, svcS3.PutObjectInput)
+
+	errUnlock := l.Unlock()
+	assert.ErrorIs(t, errUnlock, denied)
+	assert.NotErrorIs(t, errUnlock, ErrNotLocked)
+
+	errForceUnlock := l.ForceUnlock()
+	assert.ErrorIs(t, errForceUnlock, denied)
+	assert.NotErrorIs(t, errForceUnlock, ErrNotLocked)
+	assert.Nil(t, svcS3.DeleteObjectInput)
+}
+
 func TestS3ObjectLockWait(t *testing.T) {
 
 	svcS3 := mocks.MockS3Client{
@@ -203,3 +250,192 @@ func TestS3ObjectLockWaitError(t *testing.T) {
 	assert.Error(t, errWaitForLock)
 	assert.Equal(t, "unable to obtain lock after 1µs: context deadline exceeded", errWaitForLock.Error())
 }
+
+func TestS3ObjectLockWaitStale(t *testing.T) {
+
+	// Pretend that the lock is locked by a prior session of node 0
+	prior := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &mocks.MockS3Client{})
+	svcS3 := mocks.MockS3Client{
+		HeadObjectOutput: &s3.HeadObjectOutput{},
+		GetObjectOutput: &s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader(prior.GetLockBody())),
+		},
+	}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+	l.SetBackoff(NewConstantBackoff(time.Minute, 0))
+
+	// Strict ownership waits for the lock of the prior session
+	errWaitForLock := l.WaitForLock(time.Millisecond)
+	assert.ErrorIs(t, errWaitForLock, context.DeadlineExceeded)
+
+	svcS3.GetObjectOutput.Body = ioutil.NopCloser(bytes.NewReader(prior.GetLockBody()))
+	l.SetOwnershipMode(OwnershipModeNode)
+	errWaitForLock = l.WaitForLock(DefaultTimeout)
+	assert.NoError(t, errWaitForLock)
+
+	// Pretend that the lock is locked by node 1 and has expired
+	other := NewS3ObjectLock(1, "bucket", "key", "kmsKeyArn", &mocks.MockS3Client{})
+	other.SetTimeout(1 * time.Nanosecond)
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body:         ioutil.NopCloser(bytes.NewReader(other.GetLockBody())),
+		LastModified: aws.Time(time.Now().Add(-DefaultClockSkew - time.Second)),
+	}
+
+	errWaitForLock = l.WaitForLock(DefaultTimeout)
+	assert.NoError(t, errWaitForLock)
+}
+
+func TestS3ObjectLockClockSkew(t *testing.T) {
+
+	c := NewFakeClock(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	// Pretend that the lock was written by node 1 with a clock that runs an hour fast
+	other := NewS3ObjectLock(1, "bucket", "key", "kmsKeyArn", &mocks.MockS3Client{})
+	other.SetClock(NewFakeClock(c.Now().Add(time.Hour)))
+	body := other.GetLockBody()
+	svcS3 := mocks.MockS3Client{
+		HeadObjectOutput: &s3.HeadObjectOutput{},
+		PutObjectOutput:  &s3.PutObjectOutput{},
+		// S3 records the time the lock was written
+		GetObjectOutput: &s3.GetObjectOutput{
+			Body:         ioutil.NopCloser(bytes.NewReader(body)),
+			LastModified: aws.Time(c.Now()),
+		},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+	}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+	l.SetClock(c)
+	assert.Equal(t, DefaultClockSkew, l.GetClockSkew())
+
+	// the fast timestamp in the lock body does not keep the lock alive
+	c.Advance(DefaultTimeout + time.Second)
+	errLock := l.Lock()
+	assert.Error(t, errLock)
+
+	// the lock expires once the default skew allowance has passed
+	c.Advance(DefaultClockSkew)
+	svcS3.GetObjectOutput.Body = ioutil.NopCloser(bytes.NewReader(body))
+	errLock = l.Lock()
+	assert.NoError(t, errLock)
+}
+
+func TestS3ObjectLockOwner(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+
+	// nobody holds the lock
+	_, errGetOwner := l.GetOwner()
+	assert.True(t, errors.Is(errGetOwner, ErrNotLocked))
+	held, errIsHeld := l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.False(t, held)
+
+	// Pretend that the lock is held by this session
+	svcS3.HeadObjectOutput = &s3.HeadObjectOutput{}
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(l.GetLockBody())),
+	}
+
+	owner, errGetOwner := l.GetOwner()
+	assert.NoError(t, errGetOwner)
+	assert.Equal(t, uint16(0), owner.Node)
+	assert.Equal(t, l.GetID(), owner.ID)
+	assert.Equal(t, DefaultTimeout, owner.ExpiresAt.Sub(owner.Acquired))
+	assert.False(t, owner.Expired)
+
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(l.GetLockBody())),
+	}
+	held, errIsHeld = l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.True(t, held)
+
+	// Pretend that the lock is held by another node
+	other := NewS3ObjectLock(1, "bucket", "key", "kmsKeyArn", &mocks.MockS3Client{})
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(other.GetLockBody())),
+	}
+	held, errIsHeld = l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.False(t, held)
+
+	// The lock can't be read
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: io.NopCloser(iotest.ErrReader(errors.New("error get object reader"))),
+	}
+	_, errIsHeld = l.IsHeld()
+	assert.Error(t, errIsHeld)
+
+	// The lock was removed after its state was checked
+	svcS3.GetObjectOutput = nil
+	held, errIsHeld = l.IsHeld()
+	assert.NoError(t, errIsHeld)
+	assert.False(t, held)
+}
+
+func TestS3ObjectLockOwnerTransientError(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{PutObjectOutput: &s3.PutObjectOutput{}}
+	obs := &recordingObserver{}
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3,
+		WithRetryBackoff(noDelayRetry(2)),
+		WithObserver(obs.observer()),
+	)
+	assert.NoError(t, errNew)
+	assert.NoError(t, l.Lock())
+
+	// A throttled check is an error, not a lost lock
+	svcS3.HeadObjectFunc = func(params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "SlowDown"}
+	}
+	_, errGetOwner := l.GetOwnerContext(context.Background())
+	assert.Error(t, errGetOwner)
+	assert.NotErrorIs(t, errGetOwner, ErrNotLocked)
+	held, errIsHeld := l.IsHeldContext(context.Background())
+	assert.Error(t, errIsHeld)
+	assert.True(t, isRetryableS3Error(errIsHeld))
+	assert.False(t, held)
+	assert.Equal(t, []string{"acquired"}, obs.events)
+}
+
+func TestS3ObjectLockUnlockOwnership(t *testing.T) {
+
+	// Pretend that the lock is held by a prior session of node 0
+	prior := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &mocks.MockS3Client{})
+	prior.SetID(1)
+	svcS3 := mocks.MockS3Client{
+		HeadObjectOutput: &s3.HeadObjectOutput{},
+		GetObjectOutput: &s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader(prior.GetLockBody())),
+		},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+	}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "kmsKeyArn", &svcS3)
+	l.SetID(2)
+
+	// The session must match by default
+	errUnlock := l.Unlock()
+	assert.True(t, errors.Is(errUnlock, ErrWrongSession))
+
+	// The node must match even when only the node is required
+	other := NewS3ObjectLock(1, "bucket", "key", "kmsKeyArn", &svcS3)
+	other.SetOwnershipMode(OwnershipModeNode)
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(prior.GetLockBody())),
+	}
+	errUnlock = other.Unlock()
+	assert.True(t, errors.Is(errUnlock, ErrWrongNode))
+
+	// Any session of the node may unlock after opting in
+	l.SetOwnershipMode(OwnershipModeNode)
+	svcS3.GetObjectOutput = &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(prior.GetLockBody())),
+	}
+	errUnlock = l.Unlock()
+	assert.NoError(t, errUnlock)
+}
@@ -0,0 +1,87 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// GetS3Versioned returns whether the bucket of the lock object is treated as versioned
+func (l *S3ObjectLock) GetS3Versioned() bool {
+	return l.s3Versioned
+}
+
+// SetS3Versioned sets whether the bucket of the lock object is treated as versioned
+// On versioned buckets the specific version of the lock object is removed when unlocking,
+// instead of adding a delete marker, and the version is checked to detect a replaced lock.
+func (l *S3ObjectLock) SetS3Versioned(versioned bool) {
+	l.s3Versioned = versioned
+}
+
+// GetS3LockVersionID returns the version of the lock object written by this session,
+// empty if this session does not hold the lock or the bucket is not versioned
+func (l *S3ObjectLock) GetS3LockVersionID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s3LockVersionID
+}
+
+// DetectS3Versioning checks if versioning is enabled or suspended on the bucket of the lock object
+// and treats the bucket as versioned if so. The s3 client must implement LockS3VersioningClient.
+func (l *S3ObjectLock) DetectS3Versioning(ctx context.Context) error {
+	svc, ok := l.svcS3.(LockS3VersioningClient)
+	if !ok {
+		return errors.New("detecting versioning requires the s3 client to support GetBucketVersioning")
+	}
+	getBucketVersioningOutput, errGetBucketVersioning := svc.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(l.GetLockBucket()),
+	})
+	if errGetBucketVersioning != nil {
+		return fmt.Errorf("unable to get versioning of bucket %q: %w", l.GetLockBucket(), errGetBucketVersioning)
+	}
+	// Objects written while versioning is suspended still have a version, "null"
+	switch getBucketVersioningOutput.Status {
+	case types.BucketVersioningStatusEnabled, types.BucketVersioningStatusSuspended:
+		l.s3Versioned = true
+	default:
+		l.s3Versioned = false
+	}
+	return nil
+}
+
+// WithS3Versioned sets whether the bucket of S3 lock objects is treated as versioned
+func WithS3Versioned(versioned bool) Option {
+	return func(l configurable) error {
+		s3l, ok := l.(*S3ObjectLock)
+		if !ok {
+			return fmt.Errorf("S3 versioning is not supported by %T", l)
+		}
+		s3l.SetS3Versioned(versioned)
+		return nil
+	}
+}
+
+// versionID returns the version returned by S3 if the bucket is treated as versioned
+func (l *S3ObjectLock) versionID(versionID *string) string {
+	if !l.s3Versioned {
+		return ""
+	}
+	return aws.ToString(versionID)
+}
+
+// deleteLock removes the lock object, or only its version if one is given, the mutex must be held
+func (l *S3ObjectLock) deleteLock(ctx context.Context, versionID string) error {
+	deleteObjectInput := &s3.DeleteObjectInput{
+		Bucket: aws.String(l.GetLockBucket()),
+		Key:    aws.String(l.GetLockPath()),
+	}
+	if l.s3Versioned && len(versionID) > 0 {
+		deleteObjectInput.VersionId = aws.String(versionID)
+	}
+	_, errDeleteObject := l.svcS3.DeleteObject(ctx, deleteObjectInput, withoutSDKRetries)
+	return errDeleteObject
+}
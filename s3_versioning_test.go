@@ -0,0 +1,131 @@
+package safelock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deptofdefense/safelock/internal/mocks"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3ObjectLockDetectS3Versioning(t *testing.T) {
+
+	tests := map[types.BucketVersioningStatus]bool{
+		types.BucketVersioningStatusEnabled:   true,
+		types.BucketVersioningStatusSuspended: true,
+		"":                                    false,
+	}
+	for status, versioned := range tests {
+		svcS3 := mocks.MockS3Client{
+			GetBucketVersioningOutput: &s3.GetBucketVersioningOutput{Status: status},
+		}
+		l := NewS3ObjectLock(0, "bucket", "key", "", &svcS3)
+		errDetect := l.DetectS3Versioning(context.Background())
+		assert.NoError(t, errDetect)
+		assert.Equal(t, versioned, l.GetS3Versioned(), status)
+	}
+
+	l := NewS3ObjectLock(0, "bucket", "key", "", &mocks.MockS3Client{})
+	assert.Error(t, l.DetectS3Versioning(context.Background()))
+
+	l = NewS3ObjectLock(0, "bucket", "key", "", mockLockS3Client{})
+	assert.Error(t, l.DetectS3Versioning(context.Background()))
+}
+
+func TestS3ObjectLockVersioned(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:    &s3.PutObjectOutput{VersionId: aws.String("v1")},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+	}
+
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3Versioned(true))
+	assert.NoError(t, errNew)
+
+	// The version written by this session is tracked
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, "v1", l.GetS3LockVersionID())
+
+	// A lock replaced since this session wrote it is not owned by this session
+	svcS3.HeadObjectOutput = &s3.HeadObjectOutput{
+		Metadata:  svcS3.PutObjectInput.Metadata,
+		VersionId: aws.String("v2"),
+	}
+	errUnlock := l.Unlock()
+	assert.Error(t, errUnlock)
+	assert.True(t, errors.Is(errUnlock, ErrWrongSession))
+	assert.Nil(t, svcS3.DeleteObjectInput)
+
+	// Only the version that was written is removed
+	svcS3.HeadObjectOutput.VersionId = aws.String("v1")
+	errUnlock = l.Unlock()
+	assert.NoError(t, errUnlock)
+	assert.Equal(t, "v1", *svcS3.DeleteObjectInput.VersionId)
+	assert.Equal(t, "", l.GetS3LockVersionID())
+}
+
+func TestS3ObjectLockVersionedTakeover(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:    &s3.PutObjectOutput{VersionId: aws.String("v1")},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+	}
+
+	clock := NewFakeClock(time.Unix(1000, 0))
+
+	// Another session's lock that has expired
+	l0 := NewS3ObjectLock(1, "bucket", "key", "", &svcS3)
+	l0.SetClock(clock)
+	l0.SetTimeout(time.Minute)
+	errLock := l0.Lock()
+	assert.NoError(t, errLock)
+	svcS3.HeadObjectOutput = &s3.HeadObjectOutput{
+		Metadata:     svcS3.PutObjectInput.Metadata,
+		LastModified: aws.Time(clock.Now()),
+		VersionId:    aws.String("v0"),
+	}
+	clock.Advance(2 * time.Minute)
+
+	// Only the version of the expired lock that was checked is removed
+	l, errNew := NewS3ObjectLockWithOptions(0, "bucket", "key", &svcS3, WithS3Versioned(true), WithClock(clock))
+	assert.NoError(t, errNew)
+	errLock = l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, "v0", *svcS3.DeleteObjectInput.VersionId)
+	assert.Equal(t, "v1", l.GetS3LockVersionID())
+
+	// Force unlocking removes the current version
+	svcS3.HeadObjectOutput.VersionId = aws.String("v1")
+	errForceUnlock := l.ForceUnlock()
+	assert.NoError(t, errForceUnlock)
+	assert.Equal(t, "v1", *svcS3.DeleteObjectInput.VersionId)
+}
+
+func TestS3ObjectLockNotVersioned(t *testing.T) {
+
+	svcS3 := mocks.MockS3Client{
+		PutObjectOutput:    &s3.PutObjectOutput{VersionId: aws.String("v1")},
+		DeleteObjectOutput: &s3.DeleteObjectOutput{},
+	}
+
+	// Versions are ignored unless the bucket is treated as versioned
+	l := NewS3ObjectLock(0, "bucket", "key", "", &svcS3)
+	errLock := l.Lock()
+	assert.NoError(t, errLock)
+	assert.Equal(t, "", l.GetS3LockVersionID())
+
+	svcS3.HeadObjectOutput = &s3.HeadObjectOutput{
+		Metadata:  svcS3.PutObjectInput.Metadata,
+		VersionId: aws.String("v2"),
+	}
+	errUnlock := l.Unlock()
+	assert.NoError(t, errUnlock)
+	assert.Nil(t, svcS3.DeleteObjectInput.VersionId)
+}
@@ -0,0 +1,94 @@
+package safelock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer that creates the spans of lock operations
+const TracerName = "github.com/deptofdefense/safelock"
+
+// GetTracerProvider returns the OpenTelemetry tracer provider for the spans of lock operations
+// The global tracer provider is returned if none has been set.
+func (l *SafeLock) GetTracerProvider() trace.TracerProvider {
+	if l.tracerProvider == nil {
+		return otel.GetTracerProvider()
+	}
+	return l.tracerProvider
+}
+
+// SetTracerProvider sets the OpenTelemetry tracer provider for the spans of lock operations
+// Setting nil restores the global tracer provider.
+func (l *SafeLock) SetTracerProvider(tracerProvider trace.TracerProvider) {
+	l.tracerProvider = tracerProvider
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider for the spans of lock operations
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(l configurable) error {
+		if tracerProvider == nil {
+			return errors.New("tracer provider must not be nil")
+		}
+		l.SetTracerProvider(tracerProvider)
+		return nil
+	}
+}
+
+// startSpan starts the span of an operation on the lock at the URI
+func (l *SafeLock) startSpan(ctx context.Context, operation, uri string) (context.Context, trace.Span) {
+	backend := ""
+	if u, errParse := url.Parse(uri); errParse == nil {
+		backend = u.Scheme
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("safelock.uri", uri),
+		attribute.String("safelock.backend", backend),
+		attribute.Int("safelock.node", int(l.node)),
+		attribute.String("safelock.id", l.id.String()),
+	}
+	if len(l.nodeName) > 0 {
+		attrs = append(attrs, attribute.String("safelock.node_name", l.nodeName))
+	}
+	return l.GetTracerProvider().Tracer(TracerName).Start(ctx, "safelock."+operation, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the outcome of an operation and ends its span
+// The outcome of a failed operation is the reason it failed.
+func endSpan(span trace.Span, outcome string, err error, attrs ...attribute.KeyValue) {
+	if err != nil {
+		outcome = failureReason(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(append(attrs, attribute.String("safelock.outcome", outcome))...)
+	span.End()
+}
+
+// holderAttributes returns the span attributes that describe the holder of a lock
+func holderAttributes(body *lockBody) []attribute.KeyValue {
+	if body == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("safelock.holder.id", body.id.String()),
+		attribute.String("safelock.holder.acquired", body.timestamp.UTC().Format(time.RFC3339Nano)),
+	}
+	if len(body.node) == 2 {
+		attrs = append(attrs, attribute.Int("safelock.holder.node", int(binary.LittleEndian.Uint16(body.node))))
+	}
+	if len(body.nodeName) > 0 {
+		attrs = append(attrs, attribute.String("safelock.holder.node_name", body.nodeName))
+	}
+	if len(body.hostname) > 0 {
+		attrs = append(attrs, attribute.String("safelock.holder.hostname", body.hostname))
+	}
+	return attrs
+}
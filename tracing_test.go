@@ -0,0 +1,92 @@
+package safelock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttribute returns the value of a span's attribute
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracerProvider(t *testing.T) {
+
+	l := NewSafeLock(0)
+	assert.Equal(t, otel.GetTracerProvider(), l.GetTracerProvider())
+
+	tp := sdktrace.NewTracerProvider()
+	assert.NoError(t, WithTracerProvider(tp)(l))
+	assert.Equal(t, tp, l.GetTracerProvider())
+	assert.Error(t, WithTracerProvider(nil)(l))
+
+	// Restore the global tracer provider
+	l.SetTracerProvider(nil)
+	assert.Equal(t, otel.GetTracerProvider(), l.GetTracerProvider())
+}
+
+func TestFileLockTracing(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	fs := afero.NewMemMapFs()
+	l, errNew := NewFileLockWithOptions(1, "file.txt", fs, WithTracerProvider(tp))
+	require.NoError(t, errNew)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	assert.NoError(t, l.LockContext(ctx))
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	lockSpan := spans[1]
+	assert.Equal(t, "safelock.GetLockState", spans[0].Name())
+	assert.Equal(t, "unlocked", spanAttribute(spans[0], "safelock.outcome").AsString())
+	assert.Equal(t, lockSpan.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, "safelock.Lock", lockSpan.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), lockSpan.Parent().SpanID())
+	assert.Equal(t, "acquired", spanAttribute(lockSpan, "safelock.outcome").AsString())
+	assert.Equal(t, l.GetLockURI(), spanAttribute(lockSpan, "safelock.uri").AsString())
+	assert.Equal(t, "file", spanAttribute(lockSpan, "safelock.backend").AsString())
+	assert.Equal(t, int64(1), spanAttribute(lockSpan, "safelock.node").AsInt64())
+	assert.Equal(t, l.GetSessionID().String(), spanAttribute(lockSpan, "safelock.id").AsString())
+
+	// Waiting for a lock held by another node ends when the context is cancelled
+	other, errNew := NewFileLockWithOptions(2, "file.txt", fs, WithTracerProvider(tp))
+	require.NoError(t, errNew)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errWait := other.WaitForLockContext(ctx, time.Minute)
+	assert.ErrorIs(t, errWait, context.Canceled)
+
+	spans = recorder.Ended()
+	waitSpan := spans[len(spans)-1]
+	assert.Equal(t, "safelock.WaitForLock", waitSpan.Name())
+	assert.Equal(t, "canceled", spanAttribute(waitSpan, "safelock.outcome").AsString())
+	assert.Equal(t, codes.Error, waitSpan.Status().Code)
+	assert.Equal(t, int64(1), spanAttribute(waitSpan, "safelock.attempts").AsInt64())
+	assert.Equal(t, attribute.FLOAT64, spanAttribute(waitSpan, "safelock.wait_duration").Type())
+
+	assert.NoError(t, l.UnlockContext(context.Background()))
+	spans = recorder.Ended()
+	unlockSpan := spans[len(spans)-1]
+	assert.Equal(t, "safelock.Unlock", unlockSpan.Name())
+	assert.Equal(t, "released", spanAttribute(unlockSpan, "safelock.outcome").AsString())
+	assert.Equal(t, l.GetSessionID().String(), spanAttribute(unlockSpan, "safelock.holder.id").AsString())
+}